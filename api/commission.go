@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+// handleCommissionHistory lists the commission periods, oldest first. With an RFC3339 `at`
+// only the period in effect at that time is returned.
+func (p *APIPlugin) handleCommissionHistory(w http.ResponseWriter, r *http.Request) {
+	p.logger.WithFields(log.Fields{
+		"method": r.Method,
+		"path":   r.URL.Path,
+	}).Debug("Handling /commission/history request")
+
+	w.Header().Set("Content-Type", "application/json")
+	var at *time.Time
+	if value := r.URL.Query().Get("at"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			internal.JSONError(w, "invalid at time, expected RFC3339", http.StatusBadRequest)
+			return
+		}
+		at = &parsed
+	}
+
+	history, err := p.store.GetCommissionHistory()
+	if err != nil {
+		p.logger.WithError(err).Error("Failed to retrieve commission history")
+		internal.JSONError(w, fmt.Sprintf("failed to retrieve commission history: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if at != nil {
+		period := internal.CommissionAt(history, *at)
+		if period == nil {
+			internal.JSONError(w, "no commission recorded", http.StatusNotFound)
+			return
+		}
+		history = []internal.CommissionPeriod{*period}
+	}
+	if err := json.NewEncoder(w).Encode(history); err != nil {
+		p.logger.WithError(err).Warn("Failed to encode /commission/history response")
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// handleDataSources lists the fetch health of the orchestrator data sources.
+func (p *APIPlugin) handleDataSources(w http.ResponseWriter, r *http.Request) {
+	p.logger.WithFields(log.Fields{
+		"method": r.Method,
+		"path":   r.URL.Path,
+	}).Debug("Handling /datasources request")
+
+	w.Header().Set("Content-Type", "application/json")
+	health, err := p.store.GetDataSourceHealth()
+	if err != nil {
+		p.logger.WithError(err).Error("Failed to retrieve data source health")
+		internal.JSONError(w, fmt.Sprintf("failed to retrieve data source health: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(health); err != nil {
+		p.logger.WithError(err).Warn("Failed to encode /datasources response")
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// handleDeadLetters lists the events that could not be parsed or applied.
+// With ?all=true the already reprocessed ones are included.
+func (p *APIPlugin) handleDeadLetters(w http.ResponseWriter, r *http.Request) {
+	p.logger.WithFields(log.Fields{
+		"method": r.Method,
+		"path":   r.URL.Path,
+	}).Debug("Handling /deadletters request")
+
+	w.Header().Set("Content-Type", "application/json")
+	letters, err := p.store.GetDeadLetters(r.URL.Query().Get("all") == "true")
+	if err != nil {
+		p.logger.WithError(err).Error("Failed to retrieve dead letters")
+		internal.JSONError(w, fmt.Sprintf("failed to retrieve dead letters: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(letters); err != nil {
+		p.logger.WithError(err).Warn("Failed to encode /deadletters response")
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Page sizes of /jobs.
+const (
+	defaultJobsLimit = 100
+	maxJobsLimit     = 1000
+)
+
+// handleJobs lists processed jobs, newest first. They can be filtered with the ethAddress,
+// nodeType, pipeline and modelID query parameters and the RFC3339 from (inclusive) and to
+// (exclusive) times, and paged with limit and offset.
+func (p *APIPlugin) handleJobs(w http.ResponseWriter, r *http.Request) {
+	p.logger.WithFields(log.Fields{
+		"method": r.Method,
+		"path":   r.URL.Path,
+	}).Debug("Handling /jobs request")
+
+	w.Header().Set("Content-Type", "application/json")
+	filter, err := parseJobFilter(r)
+	if err != nil {
+		internal.JSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	jobs, err := p.store.GetProcessedJobs(filter)
+	if err != nil {
+		p.logger.WithError(err).Error("Failed to retrieve processed jobs")
+		internal.JSONError(w, fmt.Sprintf("failed to retrieve processed jobs: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(jobs); err != nil {
+		p.logger.WithError(err).Warn("Failed to encode /jobs response")
+	}
+}
+
+// parseJobFilter reads the /jobs query parameters.
+func parseJobFilter(r *http.Request) (internal.JobFilter, error) {
+	query := r.URL.Query()
+	filter := internal.JobFilter{
+		EthAddress: query.Get("ethAddress"),
+		NodeType:   query.Get("nodeType"),
+		Pipeline:   query.Get("pipeline"),
+		ModelID:    query.Get("modelID"),
+		Limit:      defaultJobsLimit,
+	}
+	for name, bound := range map[string]**time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := query.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s time, expected RFC3339", name)
+			}
+			*bound = &t
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxJobsLimit {
+			return filter, fmt.Errorf("limit must be between 1 and %d", maxJobsLimit)
+		}
+		filter.Limit = limit
+	}
+	if value := query.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return filter, fmt.Errorf("invalid offset")
+		}
+		filter.Offset = offset
+	}
+	return filter, nil
+}
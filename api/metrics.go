@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// handleWorkerMetrics lists the performance metrics of the workers over the worker filter
+// window, or over the last windowSeconds if given.
+func (p *APIPlugin) handleWorkerMetrics(w http.ResponseWriter, r *http.Request) {
+	p.logger.WithFields(log.Fields{
+		"method": r.Method,
+		"path":   r.URL.Path,
+	}).Debug("Handling /workers/metrics request")
+
+	w.Header().Set("Content-Type", "application/json")
+	window := p.metricsWindow
+	if value := r.URL.Query().Get("windowSeconds"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 1 {
+			internal.JSONError(w, "invalid windowSeconds", http.StatusBadRequest)
+			return
+		}
+		window = time.Duration(seconds) * time.Second
+	}
+
+	to := time.Now().UTC()
+	metrics, err := p.store.GetWorkerMetrics(to.Add(-window), to)
+	if err != nil {
+		p.logger.WithError(err).Error("Failed to compute worker metrics")
+		internal.JSONError(w, fmt.Sprintf("failed to compute worker metrics: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(metrics); err != nil {
+		p.logger.WithError(err).Warn("Failed to encode /workers/metrics response")
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// withdrawalRequest is the body of POST /admin/operator/withdrawals. Region defaults to the
+// region of the manager.
+type withdrawalRequest struct {
+	Region   string       `json:"region"`
+	NodeType string       `json:"nodeType"`
+	Amount   internal.Wei `json:"amount"`
+	TxHash   string       `json:"txHash"`
+	Note     string       `json:"note"`
+}
+
+// handleOperatorBalances lists the pool operator's commission per region and node type.
+func (p *APIPlugin) handleOperatorBalances(w http.ResponseWriter, r *http.Request) {
+	p.logger.WithFields(log.Fields{
+		"method": r.Method,
+		"path":   r.URL.Path,
+	}).Debug("Handling /admin/operator/commission request")
+
+	w.Header().Set("Content-Type", "application/json")
+	balances, err := p.store.GetOperatorBalances()
+	if err != nil {
+		p.logger.WithError(err).Error("Failed to retrieve operator balances")
+		internal.JSONError(w, fmt.Sprintf("failed to retrieve operator balances: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(balances); err != nil {
+		p.logger.WithError(err).Warn("Failed to encode /admin/operator/commission response")
+	}
+}
+
+// handleOperatorLedger lists the pool operator ledger, newest first. ?kind= selects one
+// kind of entries.
+func (p *APIPlugin) handleOperatorLedger(w http.ResponseWriter, r *http.Request) {
+	p.logger.WithFields(log.Fields{
+		"method": r.Method,
+		"path":   r.URL.Path,
+	}).Debug("Handling /admin/operator/ledger request")
+
+	w.Header().Set("Content-Type", "application/json")
+	kind := r.URL.Query().Get("kind")
+	switch kind {
+	case "", internal.OperatorCommission, internal.OperatorReassigned, internal.OperatorWithdrawal:
+	default:
+		internal.JSONError(w, "invalid kind", http.StatusBadRequest)
+		return
+	}
+	entries, err := p.store.GetOperatorLedger(kind)
+	if err != nil {
+		p.logger.WithError(err).Error("Failed to retrieve operator ledger")
+		internal.JSONError(w, fmt.Sprintf("failed to retrieve operator ledger: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		p.logger.WithError(err).Warn("Failed to encode /admin/operator/ledger response")
+	}
+}
+
+// handleOperatorWithdrawal records a withdrawal of the pool operator's commission.
+func (p *APIPlugin) handleOperatorWithdrawal(w http.ResponseWriter, r *http.Request) {
+	var req withdrawalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		internal.JSONError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Region == "" {
+		req.Region = p.region
+	}
+	if req.NodeType == "" || req.TxHash == "" || req.Amount.Sign() <= 0 {
+		internal.JSONError(w, "nodeType, txHash and a positive amount are required", http.StatusBadRequest)
+		return
+	}
+
+	if err := p.store.AddOperatorWithdrawal(req.Region, req.NodeType, req.Amount, req.TxHash, req.Note); err != nil {
+		p.logger.WithError(err).Error("Failed to record operator withdrawal")
+		internal.JSONError(w, fmt.Sprintf("failed to record operator withdrawal: %v", err), http.StatusConflict)
+		return
+	}
+	p.logger.WithFields(log.Fields{
+		"region":   req.Region,
+		"nodeType": req.NodeType,
+		"amount":   req.Amount.String(),
+		"txHash":   req.TxHash,
+	}).Info("Recorded operator withdrawal")
+	w.WriteHeader(http.StatusNoContent)
+}
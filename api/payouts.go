@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// handlePayouts lists the worker payouts in the order they were sent. ?status= selects the
+// payouts with one status. Submitted payouts whose stuck transaction is not replaced anymore
+// carry the reason in their error.
+func (p *APIPlugin) handlePayouts(w http.ResponseWriter, r *http.Request) {
+	p.logger.WithFields(log.Fields{
+		"method": r.Method,
+		"path":   r.URL.Path,
+	}).Debug("Handling /payouts request")
+
+	w.Header().Set("Content-Type", "application/json")
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", internal.PayoutSubmitted, internal.PayoutConfirmed, internal.PayoutFailed, internal.PayoutReplaced:
+	default:
+		internal.JSONError(w, "invalid status", http.StatusBadRequest)
+		return
+	}
+	payouts, err := p.store.GetPayouts(status)
+	if err != nil {
+		p.logger.WithError(err).Error("Failed to retrieve payouts")
+		internal.JSONError(w, fmt.Sprintf("failed to retrieve payouts: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(payouts); err != nil {
+		p.logger.WithError(err).Warn("Failed to encode /payouts response")
+	}
+}
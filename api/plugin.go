@@ -2,21 +2,27 @@ package main
 
 import (
 	"encoding/json"
+	"expvar"
 	"fmt"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
 	pool "github.com/Livepeer-Open-Pool/openpool-plugin"
 	"github.com/Livepeer-Open-Pool/openpool-plugin/config"
 	"github.com/Livepeer-Open-Pool/openpool-plugin/models"
 	log "github.com/sirupsen/logrus"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 type APIPlugin struct {
-	store          pool.StorageInterface
+	store          internal.Store
 	commissionRate float64
+	commission     *internal.CommissionConfig
 	region         string
 	version        string
 	portNumber     int
+	adminToken     string
+	metricsWindow  time.Duration
 	logger         *log.Entry
 }
 
@@ -30,8 +36,20 @@ func (p *APIPlugin) Init(cfg config.Config, store pool.StorageInterface) {
 	})
 	p.logger.Info("Initializing APIPlugin")
 
-	p.store = store
+	extStore, ok := store.(internal.Store)
+	if !ok {
+		p.logger.Fatal("Storage plugin does not implement the manager storage interface")
+	}
+	extCfg, err := internal.LoadConfig()
+	if err != nil {
+		p.logger.WithError(err).Fatal("Failed to load API config")
+	}
+
+	p.store = extStore
+	p.adminToken = extCfg.APIConfig.AdminToken
+	p.metricsWindow = extCfg.WorkerFilter.Window()
 	p.commissionRate = cfg.PoolCommissionRate
+	p.commission = extCfg.Commission
 	p.region = cfg.Region
 	p.version = cfg.Version
 	p.portNumber = cfg.APIConfig.ServerPort
@@ -39,8 +57,9 @@ func (p *APIPlugin) Init(cfg config.Config, store pool.StorageInterface) {
 	//TODO: need a way to get total payout dynamically from store
 
 	p.logger.WithFields(log.Fields{
-		"commissionRate": p.commissionRate,
-		"portNumber":     p.portNumber,
+		"commissionRate":  p.commissionRate,
+		"commissionRules": len(p.commission.Rules),
+		"portNumber":      p.portNumber,
 	}).Info("APIPlugin configuration loaded")
 }
 
@@ -55,12 +74,13 @@ func (p *APIPlugin) Start() {
 		}).Debug("Handling /status request")
 		status := []map[string]interface{}{
 			{
-				"Commission":   p.commissionRate,
-				"TotalPayouts": 0.00,
-				"TotalPending": 0.00,
-				"Version":      p.version,
-				"Region":       p.region,
-				"NodeTypes":    []string{"AI", "Transcoding"},
+				"Commission":      p.commissionRate,
+				"CommissionRules": p.commission.Rules,
+				"TotalPayouts":    0.00,
+				"TotalPending":    0.00,
+				"Version":         p.version,
+				"Region":          p.region,
+				"NodeTypes":       []string{"AI", "Transcoding"},
 			},
 		}
 		totalPaid, err := p.store.GetPaidFees()
@@ -76,12 +96,13 @@ func (p *APIPlugin) Start() {
 		} else {
 			status = []map[string]interface{}{
 				{
-					"Commission":   p.commissionRate,
-					"TotalPayouts": totalPaid,
-					"TotalPending": totalPending,
-					"Version":      p.version,
-					"Region":       p.region,
-					"NodeTypes":    []string{"AI", "Transcoding"},
+					"Commission":      p.commissionRate,
+					"CommissionRules": p.commission.Rules,
+					"TotalPayouts":    totalPaid,
+					"TotalPending":    totalPending,
+					"Version":         p.version,
+					"Region":          p.region,
+					"NodeTypes":       []string{"AI", "Transcoding"},
 				},
 			}
 		}
@@ -99,8 +120,7 @@ func (p *APIPlugin) Start() {
 
 		w.Header().Set("Content-Type", "application/json")
 
-		//TODO: Basic check only makes sure they are filtered,NEED iNPUT CRITIERA To do a proper search...(job type, model, pipeline>??)
-		// Check for the `filtered` query parameter
+		// With `filtered=true` only the workers that pass the worker filter policy are listed.
 		query := r.URL.Query()
 		filtered := query.Get("filtered") == "true"
 
@@ -118,7 +138,7 @@ func (p *APIPlugin) Start() {
 		if err != nil {
 			logServer.WithError(err).Error("Failed to retrieve workers")
 			// Handle the error properly by returning a 500 response with a meaningful message
-			http.Error(w, fmt.Sprintf(`{"error": "failed to retrieve workers: %v"}`, err), http.StatusInternalServerError)
+			internal.JSONError(w, fmt.Sprintf("failed to retrieve workers: %v", err), http.StatusInternalServerError)
 			return
 		}
 		if err := json.NewEncoder(w).Encode(workers); err != nil {
@@ -126,13 +146,38 @@ func (p *APIPlugin) Start() {
 		}
 	})
 
+	http.HandleFunc("GET /datasources", p.handleDataSources)
+	http.HandleFunc("GET /unattributed", p.handleUnattributedFees)
+	http.HandleFunc("GET /deadletters", p.handleDeadLetters)
+	http.HandleFunc("GET /jobs", p.handleJobs)
+	http.HandleFunc("GET /workers/metrics", p.handleWorkerMetrics)
+	http.HandleFunc("GET /commission/history", p.handleCommissionHistory)
+	http.HandleFunc("GET /payouts", p.handlePayouts)
+	http.HandleFunc("POST /admin/unattributed/{id}/reassign", internal.RequireAdmin(p.adminToken, p.logger, p.handleReassignUnattributedFees))
+	http.HandleFunc("GET /admin/operator/commission", internal.RequireAdmin(p.adminToken, p.logger, p.handleOperatorBalances))
+	http.HandleFunc("GET /admin/operator/ledger", internal.RequireAdmin(p.adminToken, p.logger, p.handleOperatorLedger))
+	http.HandleFunc("POST /admin/operator/withdrawals", internal.RequireAdmin(p.adminToken, p.logger, p.handleOperatorWithdrawal))
+
 	// Start the server
 	portStr := ":" + strconv.Itoa(p.portNumber)
 	logServer.WithField("address", portStr).Info("Starting API server")
-	if err := http.ListenAndServe(portStr, nil); err != nil {
+	if err := http.ListenAndServe(portStr, p.handler()); err != nil {
 		logServer.WithError(err).Fatal("Failed to start HTTP server")
 	}
 }
 
+// handler serves the default mux, with /debug/vars for admins only. The expvar package
+// registers /debug/vars on the default mux itself, so the check goes in front of the mux.
+func (p *APIPlugin) handler() http.Handler {
+	debugVars := internal.RequireAdmin(p.adminToken, p.logger, expvar.Handler().ServeHTTP)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/debug/vars" {
+			debugVars(w, r)
+			return
+		}
+		http.DefaultServeMux.ServeHTTP(w, r)
+	})
+}
+
 // Exported symbol for plugin loading
 var PluginInstance APIPlugin
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	"github.com/ethereum/go-ethereum/common"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// reassignRequest is the body of POST /admin/unattributed/{id}/reassign. Exactly one of
+// EthAddress and PoolOperator must be set.
+type reassignRequest struct {
+	EthAddress   string `json:"ethAddress"`
+	PoolOperator bool   `json:"poolOperator"`
+}
+
+// handleUnattributedFees lists the fees that could not be attributed to a worker.
+// With ?all=true the already reassigned fees are included.
+func (p *APIPlugin) handleUnattributedFees(w http.ResponseWriter, r *http.Request) {
+	p.logger.WithFields(log.Fields{
+		"method": r.Method,
+		"path":   r.URL.Path,
+	}).Debug("Handling /unattributed request")
+
+	w.Header().Set("Content-Type", "application/json")
+	fees, err := p.store.GetUnattributedFees(r.URL.Query().Get("all") == "true")
+	if err != nil {
+		p.logger.WithError(err).Error("Failed to retrieve unattributed fees")
+		internal.JSONError(w, fmt.Sprintf("failed to retrieve unattributed fees: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(fees); err != nil {
+		p.logger.WithError(err).Warn("Failed to encode /unattributed response")
+	}
+}
+
+// handleReassignUnattributedFees credits unattributed fees to a worker or the pool operator.
+func (p *APIPlugin) handleReassignUnattributedFees(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		internal.JSONError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	var req reassignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		internal.JSONError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	target := req.EthAddress
+	switch {
+	case req.PoolOperator && req.EthAddress == "":
+		target = internal.PoolOperator
+	case !req.PoolOperator && common.IsHexAddress(req.EthAddress):
+	default:
+		internal.JSONError(w, "set either a valid ethAddress or poolOperator", http.StatusBadRequest)
+		return
+	}
+	if target != internal.PoolOperator {
+		if target, err = p.storedWorkerAddress(target); err != nil {
+			p.logger.WithError(err).Error("Failed to retrieve workers")
+			internal.JSONError(w, fmt.Sprintf("failed to retrieve workers: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := p.store.ReassignUnattributedFees(id, target); err != nil {
+		p.logger.WithError(err).Error("Failed to reassign unattributed fees")
+		internal.JSONError(w, fmt.Sprintf("failed to reassign unattributed fees: %v", err), http.StatusConflict)
+		return
+	}
+	p.logger.WithFields(log.Fields{
+		"id":     id,
+		"target": target,
+	}).Info("Reassigned unattributed fees")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// storedWorkerAddress returns ethAddress in the case an existing worker is stored under.
+// Hex addresses match in any case, but the stores key workers case-sensitively, so
+// crediting the address as given could create a second worker. An address without a
+// worker is returned unchanged.
+func (p *APIPlugin) storedWorkerAddress(ethAddress string) (string, error) {
+	workers, err := p.store.GetRemoteWorkers()
+	if err != nil {
+		return "", err
+	}
+	for _, worker := range workers {
+		if strings.EqualFold(worker.EthAddress, ethAddress) {
+			return worker.EthAddress, nil
+		}
+	}
+	return ethAddress, nil
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	"net/http"
+)
+
+// registerAdminHandlers exposes the data loader's admin commands on the API server.
+func (p *DataLoaderPlugin) registerAdminHandlers(adminToken string) {
+	// GET only reports the diff, POST also overwrites the stored balances with the rebuilt ones.
+	http.HandleFunc("GET /admin/rebuild", internal.RequireAdmin(adminToken, p.logger, p.handleRebuild(false)))
+	http.HandleFunc("POST /admin/rebuild", internal.RequireAdmin(adminToken, p.logger, p.handleRebuild(true)))
+	http.HandleFunc("POST /admin/deadletters/reprocess", internal.RequireAdmin(adminToken, p.logger, p.handleReprocessDeadLetters))
+	http.HandleFunc("POST /admin/deadletters/{id}/reprocess", internal.RequireAdmin(adminToken, p.logger, p.handleReprocessDeadLetter))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"time"
+)
+
+// recordCommission stores the configured commission as a new period if it differs from the
+// period in effect at its EffectiveFrom, and loads the commission history events are
+// credited with.
+func (p *DataLoaderPlugin) recordCommission(rate float64, commission *internal.CommissionConfig) error {
+	// A float rate such as 0.07 is only close to its basis points, so compare with a tolerance.
+	if bps := internal.RateBasisPoints(rate); math.Abs(rate*internal.BasisPoints-float64(bps)) > 1e-6 {
+		p.logger.WithFields(log.Fields{
+			"rate":        rate,
+			"basisPoints": bps,
+		}).Warn("Pool commission rate is not a whole number of basis points, rounding it")
+	}
+
+	history, err := p.store.GetCommissionHistory()
+	if err != nil {
+		return fmt.Errorf("failed to load commission history: %w", err)
+	}
+	schedule := *commission
+	schedule.EffectiveFrom = nil
+	configured := internal.CommissionPeriod{
+		EffectiveFrom: time.Now().UTC(),
+		Rate:          rate,
+		Schedule:      schedule,
+	}
+	if commission.EffectiveFrom != nil {
+		configured.EffectiveFrom = commission.EffectiveFrom.UTC()
+	}
+	if current := internal.CommissionAt(history, configured.EffectiveFrom); current == nil || !current.SameCommission(&configured) {
+		if err := p.store.AddCommissionPeriod(configured); err != nil {
+			return fmt.Errorf("failed to record commission period: %w", err)
+		}
+		if history, err = p.store.GetCommissionHistory(); err != nil {
+			return fmt.Errorf("failed to load commission history: %w", err)
+		}
+	}
+	p.commissionHistory = history
+	p.configuredCommission = &configured
+	return nil
+}
+
+// workerShare returns the part of a job's fees credited to its worker. It applies the
+// commission period in effect at the event time: the rate of its first rule matching the
+// job, or its PoolCommissionRate if none does. Without a history the configured commission
+// applies.
+func (p *DataLoaderPlugin) workerShare(tx internal.Store, ctx internal.EventContext, payload JobProcessed) (internal.Wei, error) {
+	period := internal.CommissionAt(p.commissionHistory, ctx.EventTime)
+	if period == nil {
+		period = p.configuredCommission
+	}
+	if period == nil {
+		return internal.Wei{}, fmt.Errorf("no commission is configured")
+	}
+	rule := period.Schedule.Rule(internal.CommissionJob{
+		EthAddress: payload.EthAddress,
+		NodeType:   ctx.NodeType,
+		Region:     ctx.Region,
+		Pipeline:   payload.Pipeline,
+		ModelID:    payload.ModelID,
+	})
+	if rule == nil {
+		return payload.Fees.MulBasisPoints(internal.RateBasisPoints(period.Rate)), nil
+	}
+
+	// The volume is summed up to the job, so replaying the event log yields the same tiers.
+	var volume internal.Wei
+	if len(rule.Tiers) > 0 && payload.EthAddress != "" {
+		var err error
+		volume, err = tx.GetWorkerVolume(payload.EthAddress, ctx.EventTime.Add(-period.Schedule.VolumeWindow()), ctx.EventTime)
+		if err != nil {
+			return internal.Wei{}, fmt.Errorf("failed to fetch volume of worker %s: %w", payload.EthAddress, err)
+		}
+	}
+	bps := rule.BasisPoints(volume)
+	ctx.Logger.WithFields(log.Fields{
+		"period":      period.EffectiveFrom,
+		"rule":        rule.Name,
+		"basisPoints": bps,
+		"volume":      volume.String(),
+	}).Debug("Applying commission rule")
+	return payload.Fees.MulBasisPoints(bps), nil
+}
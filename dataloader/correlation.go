@@ -0,0 +1,62 @@
+package main
+
+import (
+	"expvar"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// Job correlation metrics, published on /debug/vars of the default HTTP mux.
+var (
+	correlationsMatched  = expvar.NewInt("dataloader_job_correlations_matched")
+	correlationsOrphaned = expvar.NewInt("dataloader_job_correlations_orphaned")
+	correlationsExpired  = expvar.NewInt("dataloader_job_correlations_expired")
+)
+
+// expireJobCorrelations drops the job correlations whose job-processed event never arrived.
+// Correlations expire in event time: the correlations of an event source expire up to the
+// earliest last event time of the data sources storing events under it, so events
+// backfilled by a file data source are not expired before their job-processed event is
+// read. The event source is locked meanwhile so no events are applied to it.
+func (p *DataLoaderPlugin) expireJobCorrelations() {
+	for eventSource, mu := range p.eventSourceLocks {
+		mu.Lock()
+		var lastEventAt int64
+		for _, ds := range p.sources {
+			if ds.eventSource != eventSource || ds.cursor.LastEventAt == 0 {
+				continue
+			}
+			if lastEventAt == 0 || ds.cursor.LastEventAt < lastEventAt {
+				lastEventAt = ds.cursor.LastEventAt
+			}
+		}
+		if lastEventAt == 0 {
+			mu.Unlock()
+			continue
+		}
+		expired, err := p.store.ExpireJobCorrelations(eventSource, time.Unix(lastEventAt, 0).UTC())
+		mu.Unlock()
+		if err != nil {
+			p.logger.WithField("source", eventSource).WithError(err).Error("Failed to expire job correlations")
+			continue
+		}
+		p.logExpiredCorrelations(expired)
+	}
+}
+
+// logExpiredCorrelations counts and logs expired job correlations.
+func (p *DataLoaderPlugin) logExpiredCorrelations(expired []internal.JobCorrelation) {
+	correlationsExpired.Add(int64(len(expired)))
+
+	for _, correlation := range expired {
+		p.logger.WithFields(log.Fields{
+			"source":     correlation.Source,
+			"requestID":  correlation.RequestID,
+			"workerAddr": correlation.EthAddress,
+			"pipeline":   correlation.Pipeline,
+			"modelID":    correlation.ModelID,
+			"receivedAt": correlation.ReceivedAt,
+		}).Warn("Job correlation expired without a job-processed event")
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// dataSource is an orchestrator the data loader ingests events from. Each data source has
+// its own cursor, poll loop, HTTP client and circuit breaker; mu serializes the processing
+// of its events. mu is shared by the data sources with the same event source, so a file
+// data source backfilling the events of an orchestrator and the orchestrator's own data
+// source do not interleave.
+type dataSource struct {
+	name string
+	// eventSource is the name the events are stored and deduplicated under: name, or for
+	// file data sources the data source whose orchestrator the events come from.
+	eventSource string
+	nodeType    string
+	endpoint    string
+	// path is set for file data sources, which read their events from it instead of
+	// fetching them from endpoint.
+	path          string
+	pushSecret    string
+	fetchInterval time.Duration
+	client        *http.Client
+	bearerToken   string
+	basicUser     string
+	basicPassword string
+	maxRetries    int
+	backoff       time.Duration
+	maxBackoff    time.Duration
+	threshold     int
+	cooldown      time.Duration
+	mu            *sync.Mutex
+	cursor        internal.DataSourceCursor
+	// failedEventID is the event that failed to apply applyFailures times in a row.
+	failedEventID int64
+	applyFailures int
+
+	// health and files are only touched by the poll loop of the data source. files holds
+	// how far the files of a file data source were read.
+	health internal.DataSourceHealth
+	files  map[string]fileState
+}
+
+// newDataSource creates a data source from its config. The name defaults to the node type
+// and the fetch interval to the data loader's.
+func newDataSource(source internal.DataSource, defaultFetchInterval int) (*dataSource, error) {
+	name := source.Name
+	if name == "" {
+		name = source.NodeType
+	}
+	fetchInterval := source.FetchIntervalSeconds
+	if fetchInterval <= 0 {
+		fetchInterval = defaultFetchInterval
+	}
+	eventSource := name
+	var path string
+	var client *http.Client
+	switch source.Type {
+	case internal.DataSourceTypeFile:
+		if source.Path == "" {
+			return nil, fmt.Errorf("data source %s: file data sources need a Path", name)
+		}
+		if source.EventSource == "" {
+			return nil, fmt.Errorf("data source %s: file data sources need the EventSource their events come from", name)
+		}
+		if source.PushSecret != "" {
+			return nil, fmt.Errorf("data source %s: file data sources do not accept pushes", name)
+		}
+		path = source.Path
+		eventSource = source.EventSource
+	case internal.DataSourceTypeHTTP:
+		var err error
+		if client, err = newHTTPClient(source); err != nil {
+			return nil, fmt.Errorf("data source %s: %w", name, err)
+		}
+	default:
+		return nil, fmt.Errorf("data source %s: unknown type %q", name, source.Type)
+	}
+	return &dataSource{
+		name:          name,
+		eventSource:   eventSource,
+		nodeType:      source.NodeType,
+		endpoint:      source.Endpoint,
+		path:          path,
+		pushSecret:    source.PushSecret,
+		fetchInterval: time.Duration(fetchInterval) * time.Second,
+		client:        client,
+		bearerToken:   source.BearerToken,
+		basicUser:     source.BasicAuthUsername,
+		basicPassword: source.BasicAuthPassword,
+		maxRetries:    source.MaxRetries,
+		backoff:       time.Duration(source.RetryBackoffMilliseconds) * time.Millisecond,
+		maxBackoff:    time.Duration(source.MaxRetryBackoffMilliseconds) * time.Millisecond,
+		threshold:     source.CircuitBreakerThreshold,
+		cooldown:      time.Duration(source.CircuitBreakerCooldownSeconds) * time.Second,
+		health: internal.DataSourceHealth{
+			Source:   name,
+			NodeType: source.NodeType,
+			State:    internal.DataSourceHealthy,
+		},
+	}, nil
+}
+
+// pollDataSource fetches the events of a data source on every tick of its fetch interval.
+func (p *DataLoaderPlugin) pollDataSource(ds *dataSource) {
+	ticker := time.NewTicker(ds.fetchInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if ds.health.CircuitOpenUntil != nil && time.Now().Before(*ds.health.CircuitOpenUntil) {
+			p.logger.WithFields(log.Fields{
+				"source":    ds.name,
+				"openUntil": *ds.health.CircuitOpenUntil,
+			}).Debug("Circuit open, skipping fetch cycle")
+			continue
+		}
+
+		p.logger.WithField("source", ds.name).Debug("Starting fetch cycle")
+		p.recordFetch(ds, p.fetchAndStoreEvents(ds))
+	}
+}
+
+// recordFetch updates the health of a data source after a fetch cycle and opens its circuit
+// once CircuitBreakerThreshold cycles in a row failed. After the cooldown one cycle is let
+// through; if it fails too the circuit opens again right away.
+func (p *DataLoaderPlugin) recordFetch(ds *dataSource, fetchErr error) {
+	now := time.Now().UTC()
+	health := &ds.health
+	health.LastAttemptAt = &now
+
+	if fetchErr == nil {
+		health.State = internal.DataSourceHealthy
+		health.LastSuccessAt = &now
+		health.ConsecutiveFailures = 0
+		health.LastError = ""
+		health.CircuitOpenUntil = nil
+	} else {
+		health.ConsecutiveFailures++
+		health.LastError = fetchErr.Error()
+		health.State = internal.DataSourceFailing
+		if health.ConsecutiveFailures >= ds.threshold {
+			openUntil := now.Add(ds.cooldown)
+			health.State = internal.DataSourceCircuitOpen
+			health.CircuitOpenUntil = &openUntil
+			p.logger.WithFields(log.Fields{
+				"source":              ds.name,
+				"consecutiveFailures": health.ConsecutiveFailures,
+				"openUntil":           openUntil,
+			}).WithError(fetchErr).Error("Data source keeps failing, opening circuit")
+		}
+	}
+
+	if err := p.store.SetDataSourceHealth(*health); err != nil {
+		p.logger.WithField("source", ds.name).WithError(err).Warn("Failed to store data source health")
+	}
+}
+
+// fetchAndStoreEvents fetches the events of a data source and processes them.
+func (p *DataLoaderPlugin) fetchAndStoreEvents(ds *dataSource) error {
+	// Safely obtain the cursor.
+	ds.mu.Lock()
+	cursor := ds.cursor
+	ds.mu.Unlock()
+
+	fetchLogger := p.logger.WithFields(log.Fields{
+		"source":   ds.name,
+		"nodeType": ds.nodeType,
+	})
+	var fetch func() ([]rawEvent, error)
+	var files map[string]fileState
+	if ds.path != "" {
+		// Only the events added to the files since they were last read are returned, the
+		// events at or before the cursor ID are dropped in processEvents.
+		fetchLogger = fetchLogger.WithFields(log.Fields{
+			"path":        ds.path,
+			"eventSource": ds.eventSource,
+		})
+		fetch = func() ([]rawEvent, error) {
+			rawEvents, read, err := ds.readEvents()
+			files = read
+			return rawEvents, err
+		}
+	} else {
+		// lastCheckTime only has second resolution, so ask for one extra second and drop the
+		// events at or before the cursor ID in processEvents.
+		lastTime := time.Time{}
+		if cursor.LastEventAt > 0 {
+			lastTime = time.Unix(cursor.LastEventAt-1, 0)
+		}
+		url := fmt.Sprintf("%s?lastCheckTime=%s&lastEventID=%d", ds.endpoint, lastTime.UTC().Format(time.RFC3339), cursor.LastEventID)
+		fetchLogger = fetchLogger.WithFields(log.Fields{
+			"endpoint": ds.endpoint,
+			"url":      url,
+		})
+		fetch = func() ([]rawEvent, error) { return ds.fetchEvents(url) }
+	}
+
+	var rawEvents []rawEvent
+	var err error
+	backoff := ds.backoff
+	for attempt := 0; ; attempt++ {
+		fetchLogger.WithField("attempt", attempt+1).Debug("Fetching events")
+		rawEvents, err = fetch()
+		if err == nil {
+			break
+		}
+		if attempt >= ds.maxRetries {
+			fetchLogger.WithError(err).Error("Fetching events failed, giving up for this cycle")
+			return err
+		}
+		fetchLogger.WithFields(log.Fields{
+			"attempt": attempt + 1,
+			"backoff": backoff,
+		}).WithError(err).Warn("Fetching events failed, retrying")
+		time.Sleep(backoff)
+		backoff = min(backoff*2, ds.maxBackoff)
+	}
+
+	// A failed event is logged and retried next cycle. It is not held against the health of
+	// the data source, which served the events fine.
+	_ = p.processEvents(ds, rawEvents, false, fetchLogger)
+	if ds.path != "" {
+		// The files are only marked as read once all their events were processed, so the
+		// events after a failed one are read again.
+		ds.mu.Lock()
+		processed := ds.cursor.LastEventID
+		ds.mu.Unlock()
+		read := true
+		for _, raw := range rawEvents {
+			read = read && int64(raw.ID) <= processed
+		}
+		if read {
+			ds.files = files
+		}
+	}
+	fetchLogger.WithField("numFetched", len(rawEvents)).Info("Finished fetching new events")
+	return nil
+}
+
+// fetchEvents performs a single request for the events at url.
+func (ds *dataSource) fetchEvents(url string) ([]rawEvent, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if ds.basicUser != "" {
+		req.SetBasicAuth(ds.basicUser, ds.basicPassword)
+	} else if ds.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+ds.bearerToken)
+	}
+
+	resp, err := ds.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP GET failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var rawEvents []rawEvent
+	if err := json.Unmarshal(body, &rawEvents); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON into rawEvents: %w", err)
+	}
+	return rawEvents, nil
+}
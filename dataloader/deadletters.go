@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+)
+
+var (
+	errDeadLetterNotFound = errors.New("dead letter not found")
+	errDeadLetterResolved = errors.New("dead letter was already reprocessed")
+)
+
+// reprocessFailure is a dead letter that failed to reprocess.
+type reprocessFailure struct {
+	ID    int64  `json:"id"`
+	Error string `json:"error"`
+}
+
+// reprocessReport is the result of reprocessing all open dead letters.
+type reprocessReport struct {
+	Reprocessed int                `json:"reprocessed"`
+	Failed      []reprocessFailure `json:"failed"`
+}
+
+// deadLetter records an event that cannot be parsed or applied and advances the cursor
+// past it.
+func (p *DataLoaderPlugin) deadLetter(ds *dataSource, raw rawEvent, reason error, cursor internal.DataSourceCursor) error {
+	envelope, err := json.Marshal(raw)
+	if err != nil {
+		return fmt.Errorf("failed to encode dead letter envelope: %w", err)
+	}
+	if err := p.store.AddDeadLetter(internal.DeadLetter{
+		Source:   ds.eventSource,
+		EventID:  int64(raw.ID),
+		NodeType: ds.nodeType,
+		Envelope: string(envelope),
+		Error:    reason.Error(),
+	}); err != nil {
+		return fmt.Errorf("failed to record dead letter: %w", err)
+	}
+	ds.failedEventID, ds.applyFailures = 0, 0
+	return p.store.SetCursor(cursor)
+}
+
+// reprocessDeadLetter parses and applies a dead-lettered event again. The event log row,
+// the worker mutation and the resolution of the dead letter are written in one storage
+// transaction. The data source cursor is not touched, it is already past the event.
+func (p *DataLoaderPlugin) reprocessDeadLetter(id int64) error {
+	letter, err := p.store.GetDeadLetter(id)
+	if err != nil {
+		return fmt.Errorf("failed to load dead letter: %w", err)
+	}
+	if letter == nil {
+		return errDeadLetterNotFound
+	}
+	if letter.ResolvedAt != nil {
+		return errDeadLetterResolved
+	}
+	// Dead letters are stored under the event source, the lock it shares with the data
+	// sources that ingest its events keeps them from applying events meanwhile.
+	if mu, ok := p.eventSourceLocks[letter.Source]; ok {
+		mu.Lock()
+		defer mu.Unlock()
+	}
+
+	reprocessLogger := p.logger.WithFields(log.Fields{
+		"deadLetterID": id,
+		"source":       letter.Source,
+		"eventID":      letter.EventID,
+	})
+	if err := p.applyDeadLetter(letter, reprocessLogger); err != nil {
+		reprocessLogger.WithError(err).Warn("Failed to reprocess dead letter")
+		if failErr := p.store.FailDeadLetter(id, err.Error()); failErr != nil {
+			reprocessLogger.WithError(failErr).Error("Failed to record dead letter attempt")
+		}
+		return err
+	}
+	reprocessLogger.Info("Reprocessed dead letter")
+	return nil
+}
+
+func (p *DataLoaderPlugin) applyDeadLetter(letter *internal.DeadLetter, reprocessLogger *log.Entry) error {
+	var raw rawEvent
+	if err := json.Unmarshal([]byte(letter.Envelope), &raw); err != nil {
+		return fmt.Errorf("invalid envelope: %w", err)
+	}
+	event, mutation, err := p.decodeEvent(letter.Source, letter.NodeType, raw, reprocessLogger)
+	if err != nil {
+		return err
+	}
+	applied, err := p.store.ApplyEvent(event, func(tx internal.Store) error {
+		if mutation != nil {
+			if err := mutation(tx); err != nil {
+				return err
+			}
+		}
+		return tx.ResolveDeadLetter(letter.ID)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply event: %w", err)
+	}
+	if !applied {
+		// The event was ingested again after it was dead-lettered.
+		reprocessLogger.Info("Dead-lettered event was already applied")
+		return p.store.ResolveDeadLetter(letter.ID)
+	}
+	return nil
+}
+
+// handleReprocessDeadLetter reprocesses a single dead letter.
+func (p *DataLoaderPlugin) handleReprocessDeadLetter(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		internal.JSONError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	err = p.reprocessDeadLetter(id)
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, errDeadLetterNotFound):
+		internal.JSONError(w, "dead letter not found", http.StatusNotFound)
+	case errors.Is(err, errDeadLetterResolved):
+		internal.JSONError(w, "dead letter was already reprocessed", http.StatusConflict)
+	default:
+		internal.JSONError(w, fmt.Sprintf("failed to reprocess dead letter: %v", err), http.StatusUnprocessableEntity)
+	}
+}
+
+// handleReprocessDeadLetters reprocesses every open dead letter in order.
+func (p *DataLoaderPlugin) handleReprocessDeadLetters(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	letters, err := p.store.GetDeadLetters(false)
+	if err != nil {
+		p.logger.WithError(err).Error("Failed to retrieve dead letters")
+		internal.JSONError(w, fmt.Sprintf("failed to retrieve dead letters: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	report := reprocessReport{}
+	for _, letter := range letters {
+		if err := p.reprocessDeadLetter(letter.ID); err != nil {
+			report.Failed = append(report.Failed, reprocessFailure{ID: letter.ID, Error: err.Error()})
+			continue
+		}
+		report.Reprocessed++
+	}
+	p.logger.WithFields(log.Fields{
+		"reprocessed": report.Reprocessed,
+		"failed":      len(report.Failed),
+	}).Info("Reprocessed dead letters")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		p.logger.WithError(err).Warn("Failed to encode /admin/deadletters/reprocess response")
+	}
+}
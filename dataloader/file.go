@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxEventLineBytes limits the size of a single JSONL envelope.
+const maxEventLineBytes = 10 << 20
+
+// fileState is how far a file of a file data source was read.
+type fileState struct {
+	size    int64
+	modTime time.Time
+	// offset is where the unread part of a JSONL file starts.
+	offset int64
+}
+
+// readEvents reads the events of a file data source that were added since the files were
+// last marked as read, and returns them with the states to mark the files as read. A
+// directory is read file by file in name order, skipping files that are neither JSON nor
+// JSONL. Unchanged files are skipped, JSONL files that grew are read from where they were
+// left and JSON files that changed are read again.
+func (ds *dataSource) readEvents() ([]rawEvent, map[string]fileState, error) {
+	info, err := os.Stat(ds.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var paths []string
+	if !info.IsDir() {
+		paths = []string{ds.path}
+	} else {
+		entries, err := os.ReadDir(ds.path)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() && isEventFile(entry.Name()) {
+				paths = append(paths, filepath.Join(ds.path, entry.Name()))
+			}
+		}
+		sort.Strings(paths)
+	}
+
+	var rawEvents []rawEvent
+	files := make(map[string]fileState, len(paths))
+	for _, path := range paths {
+		fileEvents, state, err := readEventFile(path, ds.files[path])
+		if err != nil {
+			return nil, nil, err
+		}
+		rawEvents = append(rawEvents, fileEvents...)
+		files[path] = state
+	}
+	return rawEvents, files, nil
+}
+
+// isEventFile reports whether a file name has a JSON or JSONL extension.
+func isEventFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json", ".jsonl", ".ndjson":
+		return true
+	}
+	return false
+}
+
+// isJSONLFile reports whether a file holds one envelope per line.
+func isJSONLFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".jsonl" || ext == ".ndjson"
+}
+
+// readEventFile reads the events a file holds beyond read, the state it was last marked as
+// read with, and returns them with its new state. Files with a .jsonl or .ndjson extension
+// hold one envelope per line and are expected to only be appended to; a JSONL file that
+// shrank is read from its start. Other files hold the JSON array returned by /pool/events.
+func readEventFile(path string, read fileState) ([]rawEvent, fileState, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, read, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, read, err
+	}
+	state := fileState{size: info.Size(), modTime: info.ModTime()}
+	if state.size == read.size && state.modTime.Equal(read.modTime) {
+		return nil, read, nil
+	}
+
+	if !isJSONLFile(path) {
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return nil, read, err
+		}
+		var rawEvents []rawEvent
+		if err := json.Unmarshal(data, &rawEvents); err != nil {
+			return nil, read, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		return rawEvents, state, nil
+	}
+
+	if read.offset <= state.size {
+		state.offset = read.offset
+	}
+	if _, err := f.Seek(state.offset, io.SeekStart); err != nil {
+		return nil, read, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, read, err
+	}
+	// A last line without a newline may still be being written, it is only read once it
+	// holds a whole envelope.
+	complete := data
+	if end := bytes.LastIndexByte(data, '\n') + 1; end < len(data) && !json.Valid(bytes.TrimSpace(data[end:])) {
+		complete = data[:end]
+	}
+
+	var rawEvents []rawEvent
+	scanner := bufio.NewScanner(bytes.NewReader(complete))
+	scanner.Buffer(nil, maxEventLineBytes)
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var raw rawEvent
+		if err := json.Unmarshal(text, &raw); err != nil {
+			return nil, read, fmt.Errorf("failed to parse %s line %d after byte %d: %w", path, line, state.offset, err)
+		}
+		rawEvents = append(rawEvents, raw)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, read, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	state.offset += int64(len(complete))
+	return rawEvents, state, nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	log "github.com/sirupsen/logrus"
+	"path/filepath"
+	"plugin"
+)
+
+// registerBuiltinHandlers registers the handlers of the events go-livepeer publishes, for
+// every envelope version the data loader supports.
+func (p *DataLoaderPlugin) registerBuiltinHandlers() error {
+	builtins := []struct {
+		eventType string
+		version   int
+		handler   internal.EventHandler
+	}{
+		{"orchestrator-reset", 1, versioned(upgradeOrchestratorResetV1, p.handleOrchestratorReset)},
+		{"worker-connected", 1, versioned(upgradeWorkerConnectedV1, p.handleWorkerConnected)},
+		{"worker-disconnected", 1, versioned(upgradeWorkerDisconnectedV1, p.handleWorkerDisconnected)},
+		{"job-received", 1, versioned(upgradeJobReceivedV1, p.handleJobReceived)},
+		{"job-processed", 1, versioned(upgradeJobProcessedV1, p.handleJobProcessed)},
+	}
+	for _, builtin := range builtins {
+		if err := p.handlers.Register(builtin.eventType, builtin.version, builtin.handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadHandlerPlugins loads the event handler plugins from the plugin path and lets them
+// register their handlers.
+func (p *DataLoaderPlugin) loadHandlerPlugins(pluginPath string, names []string) error {
+	for _, name := range names {
+		path := filepath.Join(pluginPath, name)
+		handlerPlugin, err := plugin.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to load event handler plugin %s: %w", path, err)
+		}
+		symbol, err := handlerPlugin.Lookup("PluginInstance")
+		if err != nil {
+			return fmt.Errorf("failed to find symbol 'PluginInstance' in %s: %w", path, err)
+		}
+		instance, ok := symbol.(internal.EventHandlerPlugin)
+		if !ok {
+			return fmt.Errorf("plugin %s does not implement EventHandlerPlugin", path)
+		}
+		if err := instance.RegisterEventHandlers(p.handlers); err != nil {
+			return fmt.Errorf("failed to register event handlers of %s: %w", path, err)
+		}
+		p.logger.WithField("plugin", path).Info("Loaded event handler plugin")
+	}
+	return nil
+}
+
+// eventMutation returns the change an event makes to the pool state, or nil if the event
+// does not change it or has no handler. Events of a known type with an unsupported version
+// or an invalid payload return an error.
+func (p *DataLoaderPlugin) eventMutation(ctx internal.EventContext, eventType string, version int, payload json.RawMessage) (func(tx internal.Store) error, error) {
+	version = envelopeVersion(version)
+	handler, ok := p.handlers.Lookup(eventType, version)
+	if !ok {
+		if supported := p.handlers.Versions(eventType); len(supported) > 0 {
+			return nil, fmt.Errorf("unsupported version %d of event type %s, supported versions are %v", version, eventType, supported)
+		}
+		ctx.Logger.WithFields(log.Fields{
+			"eventType": eventType,
+			"version":   version,
+		}).Warn("Unknown event type")
+		return nil, nil
+	}
+	mutation, err := handler(ctx, payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload for %s event: %w", eventType, err)
+	}
+	return mutation, nil
+}
+
+// handleOrchestratorReset ends the sessions of every worker connected to the orchestrator.
+func (p *DataLoaderPlugin) handleOrchestratorReset(ctx internal.EventContext, payload OrchestratorReset) (func(tx internal.Store) error, error) {
+	// Only the sessions of this orchestrator end, workers stay connected to the others.
+	return func(tx internal.Store) error {
+		if err := tx.CloseSourceSessions(ctx.Source, ctx.Region, ctx.NodeType, ctx.EventTime); err != nil {
+			return fmt.Errorf("failed to reset workers online status: %w", err)
+		}
+		return nil
+	}, nil
+}
+
+// handleWorkerConnected opens a session of the worker with the orchestrator.
+func (p *DataLoaderPlugin) handleWorkerConnected(ctx internal.EventContext, payload WorkerConnected) (func(tx internal.Store) error, error) {
+	return func(tx internal.Store) error {
+		if err := tx.OpenWorkerSession(internal.WorkerSession{
+			Source:      ctx.Source,
+			EthAddress:  payload.EthAddress,
+			NodeType:    ctx.NodeType,
+			Region:      ctx.Region,
+			Connection:  payload.Connection,
+			ConnectedAt: ctx.EventTime,
+		}); err != nil {
+			return fmt.Errorf("failed to update worker %s status to online: %w", payload.EthAddress, err)
+		}
+		return nil
+	}, nil
+}
+
+// handleWorkerDisconnected closes the session of the worker with the orchestrator.
+func (p *DataLoaderPlugin) handleWorkerDisconnected(ctx internal.EventContext, payload WorkerDisconnected) (func(tx internal.Store) error, error) {
+	return func(tx internal.Store) error {
+		if err := tx.CloseWorkerSession(ctx.Source, payload.EthAddress, ctx.Region, ctx.NodeType, ctx.EventTime); err != nil {
+			return fmt.Errorf("failed to update worker %s status to offline: %w", payload.EthAddress, err)
+		}
+		return nil
+	}, nil
+}
+
+// handleJobReceived remembers which worker an AI job was sent to, so its job-processed
+// event can be attributed. The correlation expires relative to the event time, so backfilled
+// and replayed jobs expire like they did live.
+func (p *DataLoaderPlugin) handleJobReceived(ctx internal.EventContext, payload JobReceived) (func(tx internal.Store) error, error) {
+	if payload.NodeType != "ai" {
+		return nil, nil
+	}
+	return func(tx internal.Store) error {
+		if err := tx.AddJobCorrelation(internal.JobCorrelation{
+			Source:     ctx.Source,
+			RequestID:  payload.RequestID,
+			EthAddress: payload.EthAddress,
+			NodeType:   payload.NodeType,
+			Pipeline:   payload.Pipeline,
+			ModelID:    payload.ModelID,
+			ReceivedAt: ctx.EventTime,
+			ExpiresAt:  ctx.EventTime.Add(p.correlationTTL).UTC(),
+		}); err != nil {
+			return fmt.Errorf("failed to store job correlation %s: %w", payload.RequestID, err)
+		}
+		return nil
+	}, nil
+}
+
+// handleJobProcessed credits the fees of a processed job, less the pool commission, to
+// the worker that processed it, and the pool commission to the pool operator.
+func (p *DataLoaderPlugin) handleJobProcessed(ctx internal.EventContext, payload JobProcessed) (func(tx internal.Store) error, error) {
+	return func(tx internal.Store) error {
+		if payload.NodeType == "ai" {
+			received, err := tx.TakeJobCorrelation(ctx.Source, payload.RequestID)
+			if err != nil {
+				return fmt.Errorf("failed to fetch job correlation %s: %w", payload.RequestID, err)
+			}
+			if received != nil {
+				payload.EthAddress = received.EthAddress
+				if payload.Pipeline == "" {
+					payload.Pipeline = received.Pipeline
+				}
+				if payload.ModelID == "" {
+					payload.ModelID = received.ModelID
+				}
+				if !ctx.Replay {
+					correlationsMatched.Add(1)
+				}
+			} else {
+				if !ctx.Replay {
+					correlationsOrphaned.Add(1)
+				}
+				ctx.Logger.WithField("requestID", payload.RequestID).Warn("No job-received event found for processed AI job")
+			}
+		}
+		feeAfterCommission, err := p.workerShare(tx, ctx, payload)
+		if err != nil {
+			return err
+		}
+		if err := tx.AddProcessedJob(internal.ProcessedJob{
+			Source:              ctx.Source,
+			EventID:             ctx.EventID,
+			RequestID:           payload.RequestID,
+			EthAddress:          payload.EthAddress,
+			NodeType:            ctx.NodeType,
+			Region:              ctx.Region,
+			Pipeline:            payload.Pipeline,
+			ModelID:             payload.ModelID,
+			ComputeUnits:        payload.ComputeUnits,
+			PricePerComputeUnit: payload.PricePerComputeUnit,
+			ResponseTime:        payload.ResponseTime,
+			Fees:                payload.Fees,
+			WorkerFees:          feeAfterCommission,
+			ProcessedAt:         ctx.EventTime,
+		}); err != nil {
+			return fmt.Errorf("failed to record processed job %s: %w", payload.RequestID, err)
+		}
+		if poolShare := payload.Fees.Sub(feeAfterCommission); poolShare.Sign() != 0 {
+			if err := tx.AddOperatorLedgerEntry(internal.OperatorLedgerEntry{
+				Kind:      internal.OperatorCommission,
+				Region:    ctx.Region,
+				NodeType:  ctx.NodeType,
+				Amount:    poolShare,
+				Source:    ctx.Source,
+				EventID:   ctx.EventID,
+				RequestID: payload.RequestID,
+			}); err != nil {
+				return fmt.Errorf("failed to record pool commission of job %s: %w", payload.RequestID, err)
+			}
+		}
+		if payload.EthAddress == "" {
+			// Keep the fees out of the worker balances until an admin reassigns them.
+			ctx.Logger.WithField("requestID", payload.RequestID).Warn("Recording fees of processed job without a worker as unattributed")
+			if err := tx.AddUnattributedFees(internal.UnattributedFee{
+				Source:    ctx.Source,
+				EventID:   ctx.EventID,
+				RequestID: payload.RequestID,
+				NodeType:  ctx.NodeType,
+				Region:    ctx.Region,
+				Fees:      feeAfterCommission,
+			}); err != nil {
+				return fmt.Errorf("failed to record unattributed fees of job %s: %w", payload.RequestID, err)
+			}
+			return nil
+		}
+		if err := tx.AddPendingFeesWei(payload.EthAddress, feeAfterCommission, ctx.Region, ctx.NodeType); err != nil {
+			return fmt.Errorf("failed to update worker %s pending fees: %w", payload.EthAddress, err)
+		}
+		return nil
+	}, nil
+}
@@ -3,12 +3,11 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
 	pool "github.com/Livepeer-Open-Pool/openpool-plugin"
 	"github.com/Livepeer-Open-Pool/openpool-plugin/config"
-	"github.com/Livepeer-Open-Pool/openpool-plugin/models"
 	log "github.com/sirupsen/logrus"
-	"io/ioutil"
-	"net/http"
+	"sort"
 	"sync"
 	"time"
 )
@@ -16,19 +15,34 @@ import (
 // DataLoaderPlugin handles fetching events from external APIs,
 // ordering them, and processing them.
 type DataLoaderPlugin struct {
-	store          pool.StorageInterface
-	apiEndpoints   map[string]string
-	nodeTypes      map[string]string
-	lastCheck      map[string]time.Time
-	receivedJobs   map[string]JobReceived
-	poolCommission float64
-	fetchInterval  int
-	region         string
-	mu             sync.Mutex
-	logger         *log.Entry
+	store   internal.Store
+	sources map[string]*dataSource
+	// eventSourceLocks holds the mutex shared by the data sources of each event source.
+	eventSourceLocks map[string]*sync.Mutex
+	handlers         *internal.EventRegistry
+	correlationTTL   time.Duration
+	// maxApplyAttempts is the number of times in a row an event may fail to apply before
+	// it is dead-lettered.
+	maxApplyAttempts int
+	// commissionHistory holds the commission periods sorted by EffectiveFrom.
+	commissionHistory []internal.CommissionPeriod
+	// configuredCommission is the configured commission, applied if the history is empty.
+	configuredCommission *internal.CommissionPeriod
+	fetchInterval        int
+	region               string
+	pushAddress          string
+	logger               *log.Entry
 }
 
-// JobReceived represents the payload for "job-received" events.
+// rawEvent is the envelope the orchestrator publishes on /pool/events.
+type rawEvent struct {
+	ID      int    `json:"ID"`
+	Payload string `json:"Payload"`
+	Version int    `json:"Version"`
+	DT      string `json:"DT"`
+}
+
+// JobReceived is the canonical payload of "job-received" events.
 type JobReceived struct {
 	EthAddress string `json:"ethAddress"`
 	ModelID    string `json:"modelID"`
@@ -38,30 +52,30 @@ type JobReceived struct {
 	TaskID     int    `json:"taskID"`
 }
 
-// JobProcessed represents the payload for "job-processed" events.
+// JobProcessed is the canonical payload of "job-processed" events.
 type JobProcessed struct {
-	ComputeUnits        int    `json:"computeUnits"`
-	Fees                int64  `json:"fees"`
-	NodeType            string `json:"nodeType"`
-	PricePerComputeUnit int    `json:"pricePerComputeUnit"`
-	RequestID           string `json:"requestID"`
-	ResponseTime        int64  `json:"responseTime"`
-	EthAddress          string `json:"ethAddress,omitempty"`
-	Pipeline            string `json:"pipeline,omitempty"`
-	ModelID             string `json:"modelID,omitempty"`
+	ComputeUnits        int          `json:"computeUnits"`
+	Fees                internal.Wei `json:"fees"`
+	NodeType            string       `json:"nodeType"`
+	PricePerComputeUnit int          `json:"pricePerComputeUnit"`
+	RequestID           string       `json:"requestID"`
+	ResponseTime        int64        `json:"responseTime"`
+	EthAddress          string       `json:"ethAddress,omitempty"`
+	Pipeline            string       `json:"pipeline,omitempty"`
+	ModelID             string       `json:"modelID,omitempty"`
 }
 
-// OrchestratorReset represents an empty payload for "orchestrator-reset" events.
+// OrchestratorReset is the canonical, empty payload of "orchestrator-reset" events.
 type OrchestratorReset struct{}
 
-// WorkerConnected represents the payload for "worker-connected" events.
+// WorkerConnected is the canonical payload of "worker-connected" events.
 type WorkerConnected struct {
 	Connection string `json:"connection"`
 	EthAddress string `json:"ethAddress"`
 	NodeType   string `json:"nodeType"`
 }
 
-// WorkerDisconnected represents the payload for "worker-disconnected" events.
+// WorkerDisconnected is the canonical payload of "worker-disconnected" events.
 type WorkerDisconnected struct {
 	EthAddress string `json:"ethAddress"`
 	NodeType   string `json:"nodeType"`
@@ -79,22 +93,42 @@ func (p *DataLoaderPlugin) Init(cfg config.Config, store pool.StorageInterface)
 
 	p.logger.Info("Initializing DataLoaderPlugin")
 
-	p.store = store
-	p.apiEndpoints = make(map[string]string)
-	p.nodeTypes = make(map[string]string)
-	p.receivedJobs = make(map[string]JobReceived)
-	p.poolCommission = cfg.PoolCommissionRate
+	extStore, ok := store.(internal.Store)
+	if !ok {
+		p.logger.Fatal("Storage plugin does not implement the manager storage interface")
+	}
+	p.store = extStore
+	p.sources = make(map[string]*dataSource)
+	p.eventSourceLocks = make(map[string]*sync.Mutex)
 	p.region = cfg.Region
 	p.fetchInterval = cfg.DataLoaderPluginConfig.FetchIntervalSeconds
 
-	// Initialize API endpoints and node types from config
-	for _, source := range cfg.DataLoaderPluginConfig.DataSources {
-		p.apiEndpoints[source.NodeType] = source.Endpoint
-		p.nodeTypes[source.NodeType] = source.NodeType
+	extCfg, err := internal.LoadConfig()
+	if err != nil {
+		p.logger.WithError(err).Fatal("Failed to load data loader config")
+	}
+	p.pushAddress = extCfg.DataLoaderPluginConfig.PushListenAddress
+	p.correlationTTL = time.Duration(extCfg.DataLoaderPluginConfig.JobCorrelationTTLSeconds) * time.Second
+	p.maxApplyAttempts = extCfg.DataLoaderPluginConfig.MaxApplyAttempts
+	if err := p.recordCommission(cfg.PoolCommissionRate, extCfg.Commission); err != nil {
+		p.logger.WithError(err).Fatal("Failed to record commission")
+	}
+	p.registerAdminHandlers(extCfg.APIConfig.AdminToken)
+	if !internal.ServesAdminRoutes(cfg) {
+		p.logger.Warn("API plugin is not configured, the data loader admin endpoints are not served")
+	}
+
+	p.handlers = internal.NewEventRegistry()
+	if err := p.registerBuiltinHandlers(); err != nil {
+		p.logger.WithError(err).Fatal("Failed to register event handlers")
 	}
+	if err := p.loadHandlerPlugins(cfg.PluginPath, extCfg.DataLoaderPluginConfig.HandlerPlugins); err != nil {
+		p.logger.WithError(err).Fatal("Failed to load event handler plugins")
+	}
+	p.logger.WithField("eventTypes", p.handlers.EventTypes()).Info("Registered event handlers")
 
-	// Initialize lastCheck map
-	p.lastCheck = make(map[string]time.Time)
+	// Data sources ingested before cursors existed start from the last stored event
+	// timestamp so that history is not fetched again.
 	maxTimestamp, err := p.store.GetLastEventTimestamp()
 	if err != nil {
 		p.logger.WithError(err).Warn("Error fetching max timestamp from store")
@@ -102,213 +136,199 @@ func (p *DataLoaderPlugin) Init(cfg config.Config, store pool.StorageInterface)
 	}
 	p.logger.WithField("maxTimestamp", maxTimestamp).Info("Obtained last event timestamp")
 
-	for nodeType := range p.apiEndpoints {
-		p.lastCheck[nodeType] = maxTimestamp
+	// Initialize the data sources from config. Each one is identified by its name, which
+	// defaults to its node type.
+	for _, source := range extCfg.DataLoaderPluginConfig.DataSources {
+		ds, err := newDataSource(source, p.fetchInterval)
+		if err != nil {
+			p.logger.WithError(err).Fatal("Failed to initialize data source")
+		}
+		if _, exists := p.sources[ds.name]; exists {
+			p.logger.WithField("source", ds.name).Fatal("Duplicate data source name, set a unique Name on each data source")
+		}
+
+		cursor, err := p.store.GetCursor(ds.name)
+		if err != nil {
+			p.logger.WithError(err).Fatal("Failed to load data source cursor")
+		}
+		if cursor == nil {
+			cursor = &internal.DataSourceCursor{Source: ds.name}
+			if !maxTimestamp.IsZero() {
+				cursor.LastEventAt = maxTimestamp.Unix()
+			}
+		}
+		ds.cursor = *cursor
+		if p.eventSourceLocks[ds.eventSource] == nil {
+			p.eventSourceLocks[ds.eventSource] = &sync.Mutex{}
+		}
+		ds.mu = p.eventSourceLocks[ds.eventSource]
+		p.sources[ds.name] = ds
+
 		p.logger.WithFields(log.Fields{
-			"nodeType":     nodeType,
-			"initialCheck": maxTimestamp,
-		}).Debug("Set initial lastCheck time for nodeType")
+			"source":      ds.name,
+			"nodeType":    ds.nodeType,
+			"endpoint":    ds.endpoint,
+			"path":        ds.path,
+			"lastEventID": cursor.LastEventID,
+			"lastEventAt": cursor.LastEventAt,
+		}).Info("Initialized data source")
 	}
 }
 
-// Start begins the periodic fetch cycle.
+// Start begins polling every data source and expiring job correlations.
 func (p *DataLoaderPlugin) Start() {
 	p.logger.WithField("fetchInterval", p.fetchInterval).Info("DataLoaderPlugin started")
 
-	interval := time.Duration(p.fetchInterval) * time.Second
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
-
-	// Run the fetch cycle on every tick.
-	for range ticker.C {
-		p.logger.Debug("Starting fetch cycle")
-
-		var wg sync.WaitGroup
-		wg.Add(len(p.apiEndpoints))
-		for nodeType, endpoint := range p.apiEndpoints {
-			p.logger.WithField("nodeType", nodeType).Debug("Initiating fetchAndStoreEvents in goroutine")
-			go p.fetchAndStoreEvents(nodeType, endpoint, &wg)
-		}
-		// Wait until all fetches for this cycle have finished.
-		wg.Wait()
-		p.logger.WithTime(time.Now()).Info("Completed fetch cycle")
+	if p.pushAddress != "" {
+		go p.startPushServer()
 	}
-}
-
-// fetchAndStoreEvents performs the HTTP fetch and processes the events.
-func (p *DataLoaderPlugin) fetchAndStoreEvents(nodeType, endpoint string, wg *sync.WaitGroup) {
-	defer wg.Done()
-
-	// Safely obtain the last check time.
-	p.mu.Lock()
-	lastTime := p.lastCheck[nodeType]
-	p.mu.Unlock()
 
-	url := fmt.Sprintf("%s?lastCheckTime=%s", endpoint, lastTime.UTC().Format(time.RFC3339))
-	fetchLogger := p.logger.WithFields(log.Fields{
-		"nodeType": nodeType,
-		"endpoint": endpoint,
-		"url":      url,
-	})
-	fetchLogger.Debug("Fetching events from endpoint")
-	resp, err := http.Get(url)
-	if err != nil {
-		fetchLogger.WithError(err).Error("HTTP GET failed")
-		return
+	// Each data source is polled on its own schedule so a slow orchestrator does not hold
+	// back the others.
+	for _, ds := range p.sources {
+		go p.pollDataSource(ds)
 	}
-	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fetchLogger.WithError(err).Error("Failed to read response body")
-		return
-	}
-
-	var rawEvents []struct {
-		ID      int    `json:"ID"`
-		Payload string `json:"Payload"`
-		Version int    `json:"Version"`
-		DT      string `json:"DT"`
+	ticker := time.NewTicker(time.Duration(p.fetchInterval) * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		p.expireJobCorrelations()
 	}
+}
 
-	if err := json.Unmarshal(body, &rawEvents); err != nil {
-		fetchLogger.WithError(err).Error("Failed to parse JSON into rawEvents")
-		return
-	}
+// processEvents stores the events and applies them to the worker state. It is shared by
+// polling and the push endpoint; calls are serialized per event source so events from both
+// paths, and from file data sources backfilling the same orchestrator, are applied in order.
+//
+// Events at or before the data source cursor have already been applied and are skipped.
+// The cursor advances past every event that was handled or dead-lettered; a storage failure
+// stops the batch and is returned so the event is retried on the next fetch or push, until
+// it failed maxApplyAttempts times in a row and is dead-lettered as well.
+//
+// Polled events are all the events after the cursor, pushed ones may follow a gap of
+// events that were lost. Pushed events only advance the cursor while their IDs follow it
+// without a gap; the events after a gap are applied, but the cursor stays before the gap so
+// that polling fetches the missing events. The pushed events polled again then are
+// duplicates, which advance the cursor without being applied twice.
+func (p *DataLoaderPlugin) processEvents(ds *dataSource, rawEvents []rawEvent, pushed bool, fetchLogger *log.Entry) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	sort.Slice(rawEvents, func(i, j int) bool { return rawEvents[i].ID < rawEvents[j].ID })
+
+	cursor := ds.cursor
+	cursor.Source = ds.name
 
 	for _, raw := range rawEvents {
-		// Parse the payload to determine the event type.
-		var parsedPayload struct {
-			EventType string          `json:"event_type"`
-			Payload   json.RawMessage `json:"Payload"`
+		if int64(raw.ID) <= cursor.LastEventID {
+			fetchLogger.WithFields(log.Fields{
+				"eventID":     raw.ID,
+				"lastEventID": cursor.LastEventID,
+			}).Debug("Skipping already ingested event")
+			continue
 		}
 
-		if err := json.Unmarshal([]byte(raw.Payload), &parsedPayload); err != nil {
+		advance := !pushed || int64(raw.ID) == cursor.LastEventID+1
+		if !advance {
 			fetchLogger.WithFields(log.Fields{
-				"eventID": raw.ID,
-			}).WithError(err).Warn("Error parsing payload JSON")
-			continue
+				"eventID":     raw.ID,
+				"lastEventID": cursor.LastEventID,
+			}).Debug("Pushed event follows a gap, leaving the cursor for polling")
 		}
-		parsedTime, err := time.Parse(time.RFC3339, raw.DT)
+		next, err := p.processEvent(ds, raw, cursor, advance, fetchLogger)
 		if err != nil {
-			fetchLogger.WithFields(log.Fields{
-				"eventID": raw.ID,
-				"rawDT":   raw.DT,
-			}).WithError(err).Warn("Error parsing timestamp")
-			continue
+			fetchLogger.WithField("eventID", raw.ID).WithError(err).Error("Stopping batch, event will be retried")
+			return fmt.Errorf("event %d: %w", raw.ID, err)
 		}
 
-		p.store.AddEvent(models.DefaultPoolEvent{
-			Timestamp: parsedTime.UTC().Unix(),
-			Data:      raw.Payload,
-			Type:      parsedPayload.EventType,
-		})
-
-		switch parsedPayload.EventType {
-		case "orchestrator-reset":
-			var payload OrchestratorReset
-			if err := json.Unmarshal(parsedPayload.Payload, &payload); err != nil {
-				fetchLogger.WithFields(log.Fields{
-					"eventID": raw.ID,
-				}).WithError(err).Warn("Invalid payload for orchestrator-reset event")
-				continue
-			}
-
-			if err := p.store.ResetWorkersOnlineStatus(p.region, nodeType); err != nil {
-				fetchLogger.WithFields(log.Fields{
-					"eventID": raw.ID,
-				}).WithError(err).Error("Failed to reset Workers Online Status")
-				continue
-			}
-		case "worker-connected":
-			var payload WorkerConnected
-			if err := json.Unmarshal(parsedPayload.Payload, &payload); err != nil {
-				fetchLogger.WithFields(log.Fields{
-					"eventID": raw.ID,
-				}).WithError(err).Warn("Invalid payload for worker-connected")
-				continue
-			}
-			if err := p.store.UpdateWorkerStatus(payload.EthAddress, true, p.region, nodeType); err != nil {
-				fetchLogger.WithFields(log.Fields{
-					"eventID":    raw.ID,
-					"workerAddr": payload.EthAddress,
-				}).WithError(err).Error("Failed to update worker status to online")
-				continue
-			}
-
-		case "worker-disconnected":
-			var payload WorkerDisconnected
-			if err := json.Unmarshal(parsedPayload.Payload, &payload); err != nil {
-				fetchLogger.WithFields(log.Fields{
-					"eventID": raw.ID,
-				}).WithError(err).Warn("Invalid payload for worker-disconnected")
-				continue
-			}
-			if err := p.store.UpdateWorkerStatus(payload.EthAddress, false, p.region, nodeType); err != nil {
-				fetchLogger.WithFields(log.Fields{
-					"eventID":    raw.ID,
-					"workerAddr": payload.EthAddress,
-				}).WithError(err).Error("Failed to update worker status to offline")
-				continue
-			}
-		case "job-received":
-			var payload JobReceived
-			if err := json.Unmarshal(parsedPayload.Payload, &payload); err != nil {
-				fetchLogger.WithFields(log.Fields{
-					"eventID": raw.ID,
-				}).WithError(err).Warn("Invalid payload for job-received")
-				continue
-			}
-
-			if payload.NodeType == "ai" {
-				p.mu.Lock()
-				p.receivedJobs[payload.RequestID] = payload
-				p.mu.Unlock()
-			}
+		cursor = next
+		ds.cursor = cursor
+	}
+	return nil
+}
 
-		case "job-processed":
-			var payload JobProcessed
-			if err := json.Unmarshal(parsedPayload.Payload, &payload); err != nil {
-				fetchLogger.WithFields(log.Fields{
-					"eventID": raw.ID,
-				}).WithError(err).Warn("Invalid payload for job-processed")
-				continue
-			}
+// processEvent stores a single event and applies it to the worker state. The event log row,
+// the worker mutation and, with advance, the advanced cursor are written in one storage
+// transaction. It returns the cursor. Events that cannot be parsed are dead-lettered;
+// storage failures are returned until the event failed maxApplyAttempts times.
+func (p *DataLoaderPlugin) processEvent(ds *dataSource, raw rawEvent, cursor internal.DataSourceCursor, advance bool, fetchLogger *log.Entry) (internal.DataSourceCursor, error) {
+	eventLogger := fetchLogger.WithField("eventID", raw.ID)
+	if advance {
+		cursor.LastEventID = int64(raw.ID)
+	}
 
-			feeAfterCommission := int64(float64(payload.Fees) * p.poolCommission)
+	event, mutation, err := p.decodeEvent(ds.eventSource, ds.nodeType, raw, eventLogger)
+	if err != nil {
+		eventLogger.WithError(err).Warn("Dead-lettering event that cannot be parsed")
+		return cursor, p.deadLetter(ds, raw, err, cursor)
+	}
+	if advance {
+		cursor.LastEventAt = event.CreatedAt
+	}
 
-			if payload.NodeType == "ai" {
-				p.mu.Lock()
-				if received, exists := p.receivedJobs[payload.RequestID]; exists {
-					payload.EthAddress = received.EthAddress
-					delete(p.receivedJobs, payload.RequestID)
-				}
-				p.mu.Unlock()
-			}
-			if err := p.store.AddPendingFees(payload.EthAddress, feeAfterCommission, p.region, nodeType); err != nil {
-				fetchLogger.WithFields(log.Fields{
-					"eventID":    raw.ID,
-					"workerAddr": payload.EthAddress,
-				}).WithError(err).Error("Failed to update worker pending fees")
-				continue
+	applied, err := p.store.ApplyEvent(event, func(tx internal.Store) error {
+		if mutation != nil {
+			if err := mutation(tx); err != nil {
+				return err
 			}
-		default:
-			fetchLogger.WithFields(log.Fields{
-				"eventID":   raw.ID,
-				"eventType": parsedPayload.EventType,
-			}).Warn("Unknown event type")
-			continue
 		}
-
-		// Update the lastCheck timestamp for this nodeType if this event is newer.
-		p.mu.Lock()
-		if parsedTime.After(p.lastCheck[nodeType]) {
-			p.lastCheck[nodeType] = parsedTime
+		return tx.SetCursor(cursor)
+	})
+	if err != nil {
+		if ds.failedEventID != int64(raw.ID) {
+			ds.failedEventID, ds.applyFailures = int64(raw.ID), 0
+		}
+		ds.applyFailures++
+		if ds.applyFailures < p.maxApplyAttempts {
+			return cursor, fmt.Errorf("failed to apply event: %w", err)
 		}
-		p.mu.Unlock()
+		eventLogger.WithField("attempts", ds.applyFailures).WithError(err).Error("Dead-lettering event that repeatedly failed to apply")
+		return cursor, p.deadLetter(ds, raw, err, cursor)
 	}
+	if !applied {
+		// The event was applied before, applying it again would count it twice.
+		eventLogger.Debug("Skipping duplicate event")
+		return cursor, p.store.SetCursor(cursor)
+	}
+	return cursor, nil
+}
 
-	fetchLogger.WithField("numFetched", len(rawEvents)).Info("Finished fetching new events")
+// decodeEvent parses an envelope into its event log row and the change it makes to the
+// worker state, which is nil if it does not change it.
+func (p *DataLoaderPlugin) decodeEvent(source string, nodeType string, raw rawEvent, eventLogger *log.Entry) (*internal.EventLog, func(tx internal.Store) error, error) {
+	// Parse the payload to determine the event type.
+	var parsedPayload struct {
+		EventType string          `json:"event_type"`
+		Payload   json.RawMessage `json:"Payload"`
+	}
+	if err := json.Unmarshal([]byte(raw.Payload), &parsedPayload); err != nil {
+		return nil, nil, fmt.Errorf("error parsing payload JSON: %w", err)
+	}
+	parsedTime, err := time.Parse(time.RFC3339, raw.DT)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error parsing timestamp %q: %w", raw.DT, err)
+	}
+
+	mutation, err := p.eventMutation(internal.EventContext{
+		Source:    source,
+		NodeType:  nodeType,
+		Region:    p.region,
+		EventID:   int64(raw.ID),
+		EventTime: parsedTime.UTC(),
+		Logger:    eventLogger,
+	}, parsedPayload.EventType, raw.Version, parsedPayload.Payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &internal.EventLog{
+		Source:    source,
+		EventID:   int64(raw.ID),
+		NodeType:  nodeType,
+		Version:   raw.Version,
+		Type:      parsedPayload.EventType,
+		Data:      raw.Payload,
+		CreatedAt: parsedTime.UTC().Unix(),
+	}, mutation, nil
 }
 
 // Exported symbol for plugin loading
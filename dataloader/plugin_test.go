@@ -0,0 +1,490 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal/memstore"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testSource = "orchestrator"
+	testWorker = "0x0000000000000000000000000000000000000001"
+	// testFees are the fees of every test job, of which testWorkerFees are credited to the
+	// worker at the test commission rate of 0.75.
+	testFees       = 1000
+	testWorkerFees = 750
+)
+
+// testEventTime is the time of the test event with ID 0, later events are a second apart.
+var testEventTime = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// flakyStore is an in-memory store whose ApplyEvent fails for the events in failing.
+type flakyStore struct {
+	*memstore.InMemoryStorage
+	failing map[int64]bool
+}
+
+func (s *flakyStore) ApplyEvent(event *internal.EventLog, apply func(tx internal.Store) error) (bool, error) {
+	if s.failing[event.EventID] {
+		return false, errors.New("storage unavailable")
+	}
+	return s.InMemoryStorage.ApplyEvent(event, apply)
+}
+
+// newTestStore returns a store that fails to apply the events with the failing IDs.
+func newTestStore(failing ...int64) *flakyStore {
+	store := &flakyStore{InMemoryStorage: &memstore.InMemoryStorage{}, failing: make(map[int64]bool)}
+	store.Init(nil)
+	for _, id := range failing {
+		store.failing[id] = true
+	}
+	return store
+}
+
+// newTestPlugin returns a data loader ingesting the transcode events of one data source
+// into store, starting from cursor.
+func newTestPlugin(t *testing.T, store internal.Store, cursor internal.DataSourceCursor) (*DataLoaderPlugin, *dataSource) {
+	t.Helper()
+
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+	mu := &sync.Mutex{}
+	cursor.Source = testSource
+	ds := &dataSource{
+		name:        testSource,
+		eventSource: testSource,
+		nodeType:    "transcode",
+		mu:          mu,
+		cursor:      cursor,
+	}
+	p := &DataLoaderPlugin{
+		store:            store,
+		sources:          map[string]*dataSource{testSource: ds},
+		eventSourceLocks: map[string]*sync.Mutex{testSource: mu},
+		handlers:         internal.NewEventRegistry(),
+		correlationTTL:   time.Hour,
+		maxApplyAttempts: 3,
+		configuredCommission: &internal.CommissionPeriod{
+			Rate: 0.75,
+		},
+		region: "test",
+		logger: log.NewEntry(logger),
+	}
+	if err := p.registerBuiltinHandlers(); err != nil {
+		t.Fatalf("failed to register event handlers: %v", err)
+	}
+	return p, ds
+}
+
+// jobProcessed returns the envelope of a job-processed event of the test worker.
+func jobProcessed(id int) rawEvent {
+	payload, _ := json.Marshal(map[string]interface{}{
+		"event_type": "job-processed",
+		"Payload": map[string]interface{}{
+			"computeUnits": 1,
+			"fees":         testFees,
+			"nodeType":     "transcode",
+			"requestID":    fmt.Sprintf("request-%d", id),
+			"ethAddress":   testWorker,
+		},
+	})
+	return rawEvent{
+		ID:      id,
+		Payload: string(payload),
+		Version: 1,
+		DT:      testEventTime.Add(time.Duration(id) * time.Second).Format(time.RFC3339),
+	}
+}
+
+// jobsProcessed returns the envelopes of job-processed events with the IDs.
+func jobsProcessed(ids ...int) []rawEvent {
+	events := make([]rawEvent, len(ids))
+	for i, id := range ids {
+		events[i] = jobProcessed(id)
+	}
+	return events
+}
+
+// assertPendingFees fails the test if the test worker was not credited for applied jobs.
+func assertPendingFees(t *testing.T, store internal.Store, applied int) {
+	t.Helper()
+	workers, err := store.GetRemoteWorkers()
+	if err != nil {
+		t.Fatalf("failed to get workers: %v", err)
+	}
+	var pending internal.Wei
+	for _, worker := range workers {
+		if worker.EthAddress == testWorker {
+			pending = worker.PendingFees
+		}
+	}
+	if want := internal.NewWei(int64(applied * testWorkerFees)); pending.Cmp(want) != 0 {
+		t.Errorf("pending fees = %s, want %s for %d jobs", pending, want, applied)
+	}
+}
+
+// assertCursor fails the test if the stored and in-memory cursor are not at event id.
+func assertCursor(t *testing.T, store internal.Store, ds *dataSource, id int64) {
+	t.Helper()
+	stored, err := store.GetCursor(testSource)
+	if err != nil {
+		t.Fatalf("failed to get cursor: %v", err)
+	}
+	if stored == nil || stored.LastEventID != id {
+		t.Errorf("stored cursor = %+v, want last event ID %d", stored, id)
+	}
+	if ds.cursor.LastEventID != id {
+		t.Errorf("cursor = %d, want %d", ds.cursor.LastEventID, id)
+	}
+	if wantAt := testEventTime.Add(time.Duration(id) * time.Second).Unix(); id > 0 && ds.cursor.LastEventAt != wantAt {
+		t.Errorf("cursor event time = %d, want %d", ds.cursor.LastEventAt, wantAt)
+	}
+}
+
+func TestProcessEvents(t *testing.T) {
+	type batch struct {
+		events []rawEvent
+		pushed bool
+	}
+	tests := []struct {
+		name        string
+		cursor      int64
+		batches     []batch
+		wantCursor  int64
+		wantApplied int
+	}{
+		{
+			name:        "polled events advance the cursor",
+			batches:     []batch{{events: jobsProcessed(1, 2, 3)}},
+			wantCursor:  3,
+			wantApplied: 3,
+		},
+		{
+			name:        "unordered events are applied in ID order",
+			batches:     []batch{{events: jobsProcessed(3, 1, 2)}},
+			wantCursor:  3,
+			wantApplied: 3,
+		},
+		{
+			name:        "events at or before the cursor are skipped",
+			cursor:      2,
+			batches:     []batch{{events: jobsProcessed(1, 2, 3)}},
+			wantCursor:  3,
+			wantApplied: 1,
+		},
+		{
+			name:        "polled events after a gap advance the cursor",
+			batches:     []batch{{events: jobsProcessed(1, 3)}},
+			wantCursor:  3,
+			wantApplied: 2,
+		},
+		{
+			name:        "pushed events after a gap leave the cursor before it",
+			batches:     []batch{{events: jobsProcessed(1, 3), pushed: true}},
+			wantCursor:  1,
+			wantApplied: 2,
+		},
+		{
+			name: "polling the gap applies pushed events once",
+			batches: []batch{
+				{events: jobsProcessed(1, 3), pushed: true},
+				{events: jobsProcessed(2, 3)},
+			},
+			wantCursor:  3,
+			wantApplied: 3,
+		},
+		{
+			name: "pushed events are not applied again when polled",
+			batches: []batch{
+				{events: jobsProcessed(1, 2), pushed: true},
+				{events: jobsProcessed(1, 2, 3)},
+			},
+			wantCursor:  3,
+			wantApplied: 3,
+		},
+		{
+			name: "duplicates of stored events advance the cursor only",
+			batches: []batch{
+				{events: jobsProcessed(1, 2)},
+				{events: jobsProcessed(1, 2, 3), pushed: true},
+				{events: jobsProcessed(2, 3, 4)},
+			},
+			wantCursor:  4,
+			wantApplied: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore()
+			p, ds := newTestPlugin(t, store, internal.DataSourceCursor{LastEventID: tt.cursor})
+			for _, b := range tt.batches {
+				p.processEvents(ds, b.events, b.pushed, p.logger)
+			}
+
+			assertCursor(t, store, ds, tt.wantCursor)
+			assertPendingFees(t, store, tt.wantApplied)
+			events, err := store.GetEventLog(0, 100)
+			if err != nil {
+				t.Fatalf("failed to get event log: %v", err)
+			}
+			if len(events) != tt.wantApplied {
+				t.Errorf("event log holds %d events, want %d", len(events), tt.wantApplied)
+			}
+		})
+	}
+}
+
+func TestProcessEventsCursorReset(t *testing.T) {
+	store := newTestStore()
+	p, ds := newTestPlugin(t, store, internal.DataSourceCursor{})
+	p.processEvents(ds, jobsProcessed(1, 2), false, p.logger)
+
+	// A cursor that was lost or reset fetches the stored events again, they are skipped by
+	// their source event key.
+	ds.cursor = internal.DataSourceCursor{Source: testSource}
+	p.processEvents(ds, jobsProcessed(1, 2, 3), false, p.logger)
+
+	assertCursor(t, store, ds, 3)
+	assertPendingFees(t, store, 3)
+}
+
+func TestDeadLetters(t *testing.T) {
+	unparseable := rawEvent{ID: 2, Payload: "{", Version: 1, DT: testEventTime.Format(time.RFC3339)}
+	unsupported := jobProcessed(2)
+	unsupported.Version = 99
+
+	tests := []struct {
+		name    string
+		events  []rawEvent
+		failing []int64
+		// polls is the number of times the events are polled.
+		polls      int
+		wantCursor int64
+		wantLetter bool
+		// wantApplied is the number of jobs credited.
+		wantApplied int
+	}{
+		{
+			name:        "unparseable events are dead-lettered at once",
+			events:      []rawEvent{jobProcessed(1), unparseable, jobProcessed(3)},
+			polls:       1,
+			wantCursor:  3,
+			wantLetter:  true,
+			wantApplied: 2,
+		},
+		{
+			name:        "unsupported versions are dead-lettered at once",
+			events:      []rawEvent{jobProcessed(1), unsupported, jobProcessed(3)},
+			polls:       1,
+			wantCursor:  3,
+			wantLetter:  true,
+			wantApplied: 2,
+		},
+		{
+			name:        "failing events stop the batch until they failed max apply attempts times",
+			events:      jobsProcessed(1, 2, 3),
+			failing:     []int64{2},
+			polls:       2,
+			wantCursor:  1,
+			wantApplied: 1,
+		},
+		{
+			name:        "failing events are dead-lettered after max apply attempts",
+			events:      jobsProcessed(1, 2, 3),
+			failing:     []int64{2},
+			polls:       3,
+			wantCursor:  3,
+			wantLetter:  true,
+			wantApplied: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(tt.failing...)
+			p, ds := newTestPlugin(t, store, internal.DataSourceCursor{})
+			for i := 0; i < tt.polls; i++ {
+				p.processEvents(ds, tt.events, false, p.logger)
+			}
+
+			assertCursor(t, store, ds, tt.wantCursor)
+			assertPendingFees(t, store, tt.wantApplied)
+			letters, err := store.GetDeadLetters(false)
+			if err != nil {
+				t.Fatalf("failed to get dead letters: %v", err)
+			}
+			if !tt.wantLetter {
+				if len(letters) != 0 {
+					t.Errorf("got dead letters %+v, want none", letters)
+				}
+				return
+			}
+			if len(letters) != 1 || letters[0].Source != testSource || letters[0].EventID != 2 || letters[0].Error == "" {
+				t.Fatalf("got dead letters %+v, want event 2 with its error", letters)
+			}
+			if ds.failedEventID != 0 || ds.applyFailures != 0 {
+				t.Errorf("apply failures of event %d = %d after dead-lettering, want them reset", ds.failedEventID, ds.applyFailures)
+			}
+		})
+	}
+}
+
+func TestReprocessDeadLetter(t *testing.T) {
+	tests := []struct {
+		name string
+		// fix runs before the dead letter is reprocessed.
+		fix         func(store *flakyStore, p *DataLoaderPlugin, ds *dataSource)
+		wantErr     bool
+		wantApplied int
+		wantOpen    bool
+	}{
+		{
+			name:        "reprocessing applies the event",
+			fix:         func(store *flakyStore, p *DataLoaderPlugin, ds *dataSource) { delete(store.failing, 2) },
+			wantApplied: 3,
+		},
+		{
+			name:        "an event that still fails stays dead-lettered",
+			fix:         func(store *flakyStore, p *DataLoaderPlugin, ds *dataSource) {},
+			wantErr:     true,
+			wantApplied: 2,
+			wantOpen:    true,
+		},
+		{
+			name: "an event ingested again meanwhile is not applied twice",
+			fix: func(store *flakyStore, p *DataLoaderPlugin, ds *dataSource) {
+				delete(store.failing, 2)
+				event, mutation, err := p.decodeEvent(testSource, ds.nodeType, jobProcessed(2), p.logger)
+				if err != nil {
+					t.Fatalf("failed to decode event: %v", err)
+				}
+				if _, err := store.ApplyEvent(event, mutation); err != nil {
+					t.Fatalf("failed to apply event: %v", err)
+				}
+			},
+			wantApplied: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(2)
+			p, ds := newTestPlugin(t, store, internal.DataSourceCursor{})
+			for i := 0; i < p.maxApplyAttempts; i++ {
+				p.processEvents(ds, jobsProcessed(1, 2, 3), false, p.logger)
+			}
+			tt.fix(store, p, ds)
+
+			err := p.reprocessDeadLetter(1)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("reprocessing returned %v, want %v", err, tt.wantErr)
+			}
+			assertPendingFees(t, store, tt.wantApplied)
+			assertCursor(t, store, ds, 3)
+			letter, err := store.GetDeadLetter(1)
+			if err != nil || letter == nil {
+				t.Fatalf("failed to get dead letter: %v", err)
+			}
+			if open := letter.ResolvedAt == nil; open != tt.wantOpen {
+				t.Errorf("dead letter open = %v, want %v", open, tt.wantOpen)
+			}
+			if tt.wantOpen && letter.Attempts != 2 {
+				t.Errorf("dead letter attempts = %d, want 2", letter.Attempts)
+			}
+		})
+	}
+}
+
+func TestReprocessDeadLetterErrors(t *testing.T) {
+	store := newTestStore(2)
+	p, ds := newTestPlugin(t, store, internal.DataSourceCursor{})
+	for i := 0; i < p.maxApplyAttempts; i++ {
+		p.processEvents(ds, jobsProcessed(1, 2), false, p.logger)
+	}
+	delete(store.failing, 2)
+
+	if err := p.reprocessDeadLetter(2); !errors.Is(err, errDeadLetterNotFound) {
+		t.Errorf("reprocessing an unknown dead letter returned %v, want %v", err, errDeadLetterNotFound)
+	}
+	if err := p.reprocessDeadLetter(1); err != nil {
+		t.Fatalf("failed to reprocess dead letter: %v", err)
+	}
+	if err := p.reprocessDeadLetter(1); !errors.Is(err, errDeadLetterResolved) {
+		t.Errorf("reprocessing a resolved dead letter returned %v, want %v", err, errDeadLetterResolved)
+	}
+	assertPendingFees(t, store, 2)
+}
+
+func TestHandleReprocessDeadLetterError(t *testing.T) {
+	store := newTestStore()
+	p, ds := newTestPlugin(t, store, internal.DataSourceCursor{})
+	badTime := jobProcessed(1)
+	badTime.DT = "yesterday"
+	p.processEvents(ds, []rawEvent{badTime}, false, p.logger)
+
+	// The parse error quotes the timestamp, the reply is valid JSON all the same.
+	req := httptest.NewRequest(http.MethodPost, "/admin/deadletters/1/reprocess", nil)
+	req.SetPathValue("id", "1")
+	rec := httptest.NewRecorder()
+	p.handleReprocessDeadLetter(rec, req)
+
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("reply %q is not valid JSON: %v", rec.Body.String(), err)
+	}
+	if !strings.Contains(body.Error, `"yesterday"`) {
+		t.Errorf("error = %q, want the timestamp that failed to parse", body.Error)
+	}
+}
+
+func TestHandlePushStorageFailure(t *testing.T) {
+	store := newTestStore(2)
+	p, ds := newTestPlugin(t, store, internal.DataSourceCursor{})
+	ds.pushSecret = "secret"
+
+	push := func() int {
+		body, _ := json.Marshal(jobsProcessed(1, 2))
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(ds.pushSecret))
+		mac.Write([]byte(timestamp + "." + string(body)))
+
+		req := httptest.NewRequest(http.MethodPost, "/pool/events/"+testSource, bytes.NewReader(body))
+		req.SetPathValue("source", testSource)
+		req.Header.Set(pushTimestampHeader, timestamp)
+		req.Header.Set(pushSignatureHeader, hex.EncodeToString(mac.Sum(nil)))
+		rec := httptest.NewRecorder()
+		p.handlePush(rec, req)
+		return rec.Code
+	}
+
+	if code := push(); code != http.StatusServiceUnavailable {
+		t.Errorf("push with a failing event answered %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	assertPendingFees(t, store, 1)
+	assertCursor(t, store, ds, 1)
+
+	delete(store.failing, 2)
+	if code := push(); code != http.StatusAccepted {
+		t.Errorf("retried push answered %d, want %d", code, http.StatusAccepted)
+	}
+	assertPendingFees(t, store, 2)
+	assertCursor(t, store, ds, 2)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pushSignatureHeader carries the hex encoded HMAC-SHA256 of the timestamp, a dot and the
+// request body, optionally prefixed with "sha256=".
+const pushSignatureHeader = "X-Pool-Signature"
+
+// pushTimestampHeader carries the Unix time in seconds the push was signed at.
+const pushTimestampHeader = "X-Pool-Timestamp"
+
+// maxPushSkew is how far the signing time of a push may be from the current time, which
+// bounds how long a captured push can be replayed.
+const maxPushSkew = 5 * time.Minute
+
+// maxPushBodyBytes limits the size of a single push request.
+const maxPushBodyBytes = 10 << 20
+
+// startPushServer serves the push ingestion endpoint. Orchestrators POST the same
+// event envelopes they return from /pool/events to /pool/events/{source}, where source
+// is the name of their data source.
+func (p *DataLoaderPlugin) startPushServer() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /pool/events/{source}", p.handlePush)
+
+	logServer := p.logger.WithField("address", p.pushAddress)
+	logServer.Info("Starting push ingestion server")
+	if err := http.ListenAndServe(p.pushAddress, mux); err != nil {
+		logServer.WithError(err).Fatal("Failed to start push ingestion server")
+	}
+}
+
+// handlePush verifies the signature of a pushed batch of events and processes it.
+func (p *DataLoaderPlugin) handlePush(w http.ResponseWriter, r *http.Request) {
+	source := r.PathValue("source")
+	pushLogger := p.logger.WithFields(log.Fields{
+		"source": source,
+		"remote": r.RemoteAddr,
+	})
+	pushLogger.Debug("Handling event push")
+
+	ds, ok := p.sources[source]
+	if !ok || ds.pushSecret == "" {
+		pushLogger.Warn("Rejected push for data source without a push secret")
+		internal.JSONError(w, "unknown data source", http.StatusNotFound)
+		return
+	}
+	if ds.path != "" {
+		pushLogger.Warn("Rejected push for file data source")
+		internal.JSONError(w, "file data sources do not accept pushes", http.StatusBadRequest)
+		return
+	}
+
+	timestamp := r.Header.Get(pushTimestampHeader)
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		pushLogger.Warn("Rejected push without a valid timestamp")
+		internal.JSONError(w, "invalid timestamp", http.StatusUnauthorized)
+		return
+	}
+	if skew := time.Since(time.Unix(signedAt, 0)); skew > maxPushSkew || skew < -maxPushSkew {
+		pushLogger.WithField("skew", skew).Warn("Rejected push signed outside the allowed time window")
+		internal.JSONError(w, "timestamp outside the allowed window", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPushBodyBytes))
+	if err != nil {
+		pushLogger.WithError(err).Warn("Failed to read push body")
+		internal.JSONError(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	if !validSignature(ds.pushSecret, timestamp, body, r.Header.Get(pushSignatureHeader)) {
+		pushLogger.Warn("Rejected push with invalid signature")
+		internal.JSONError(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var rawEvents []rawEvent
+	if err := json.Unmarshal(body, &rawEvents); err != nil {
+		pushLogger.WithError(err).Warn("Failed to parse pushed events")
+		internal.JSONError(w, "invalid event envelopes", http.StatusBadRequest)
+		return
+	}
+
+	// Events up to a failed one are applied; the orchestrator retries the whole push and
+	// those are skipped as duplicates then.
+	if err := p.processEvents(ds, rawEvents, true, pushLogger); err != nil {
+		internal.JSONError(w, fmt.Sprintf("failed to process pushed events: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+	pushLogger.WithField("numPushed", len(rawEvents)).Info("Processed pushed events")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// validSignature reports whether signature is the HMAC-SHA256 of timestamp + "." + body
+// under secret.
+func validSignature(secret string, timestamp string, body []byte, signature string) bool {
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil || len(got) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// rebuildPageSize is the number of event log rows read at once during a rebuild.
+const rebuildPageSize = 1000
+
+// rebuildReport is the result of replaying the event log.
+type rebuildReport struct {
+	EventsReplayed int `json:"eventsReplayed"`
+	// EventsSkipped counts events that could not be replayed, e.g. legacy events without a
+	// data source whose payload does not name a node type.
+	EventsSkipped int `json:"eventsSkipped"`
+	// UnmatchedPayouts are payouts that could not be attributed to a single rebuilt worker.
+	UnmatchedPayouts []internal.PoolPayout `json:"unmatchedPayouts"`
+	Applied          bool                  `json:"applied"`
+	Diffs            []balanceDiff         `json:"diffs"`
+}
+
+// balanceDiff is a worker whose rebuilt state differs from the stored one.
+type balanceDiff struct {
+	EthAddress         string       `json:"ethAddress"`
+	NodeType           string       `json:"nodeType"`
+	Region             string       `json:"region"`
+	CurrentPendingFees internal.Wei `json:"currentPendingFees"`
+	RebuiltPendingFees internal.Wei `json:"rebuiltPendingFees"`
+	CurrentPaidFees    internal.Wei `json:"currentPaidFees"`
+	RebuiltPaidFees    internal.Wei `json:"rebuiltPaidFees"`
+	CurrentConnected   bool         `json:"currentConnected"`
+	RebuiltConnected   bool         `json:"rebuiltConnected"`
+}
+
+// workerKey identifies a worker balance.
+type workerKey struct {
+	ethAddress string
+	nodeType   string
+	region     string
+}
+
+func (p *DataLoaderPlugin) handleRebuild(apply bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		report, err := p.rebuild(apply)
+		if err != nil {
+			p.logger.WithError(err).Error("Failed to rebuild worker balances")
+			internal.JSONError(w, fmt.Sprintf("failed to rebuild worker balances: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			p.logger.WithError(err).Warn("Failed to encode /admin/rebuild response")
+		}
+	}
+}
+
+// rebuild replays every stored event through the event handlers into a scratch store,
+// credits reassigned unattributed fees, subtracts the recorded payouts and compares the
+// result with the stored worker balances. With apply the stored balances of the differing
+// workers are overwritten. Ingestion is paused while the rebuild runs, and the rebuild runs
+// in one storage transaction, so payouts and reassignments wait for it instead of being
+// overwritten.
+func (p *DataLoaderPlugin) rebuild(apply bool) (*rebuildReport, error) {
+	rebuildLogger := p.logger.WithField("apply", apply)
+	rebuildLogger.Info("Rebuilding worker balances from the event log")
+
+	eventSources := make([]string, 0, len(p.eventSourceLocks))
+	for eventSource := range p.eventSourceLocks {
+		eventSources = append(eventSources, eventSource)
+	}
+	sort.Strings(eventSources)
+	for _, eventSource := range eventSources {
+		p.eventSourceLocks[eventSource].Lock()
+		defer p.eventSourceLocks[eventSource].Unlock()
+	}
+
+	report := &rebuildReport{}
+	if err := p.store.Transaction(func(tx internal.Store) error {
+		return p.rebuildBalances(tx, apply, report, rebuildLogger)
+	}); err != nil {
+		return nil, err
+	}
+
+	rebuildLogger.WithFields(log.Fields{
+		"eventsReplayed":   report.EventsReplayed,
+		"eventsSkipped":    report.EventsSkipped,
+		"unmatchedPayouts": len(report.UnmatchedPayouts),
+		"numDiffs":         len(report.Diffs),
+		"applied":          report.Applied,
+	}).Info("Rebuilt worker balances from the event log")
+	return report, nil
+}
+
+// rebuildBalances does the work of rebuild within the storage transaction tx.
+func (p *DataLoaderPlugin) rebuildBalances(tx internal.Store, apply bool, report *rebuildReport, rebuildLogger *log.Entry) error {
+	scratch, err := tx.NewScratchStore()
+	if err != nil {
+		return fmt.Errorf("failed to create scratch store: %w", err)
+	}
+
+	fees, err := tx.GetUnattributedFees(true)
+	if err != nil {
+		return fmt.Errorf("failed to load unattributed fees: %w", err)
+	}
+	if err := p.replayEventLog(tx, scratch, fees, report, rebuildLogger); err != nil {
+		return err
+	}
+
+	// Reassigned fees were never part of a worker balance in the event log.
+	for _, fee := range fees {
+		if fee.ReassignedTo == "" || fee.ReassignedTo == internal.PoolOperator {
+			continue
+		}
+		if err := scratch.AddPendingFeesWei(fee.ReassignedTo, fee.Fees, fee.Region, fee.NodeType); err != nil {
+			return fmt.Errorf("failed to credit reassigned fees %d: %w", fee.ID, err)
+		}
+	}
+
+	rebuiltWorkers, err := scratch.GetRemoteWorkers()
+	if err != nil {
+		return fmt.Errorf("failed to load rebuilt workers: %w", err)
+	}
+	rebuilt := make(map[workerKey]*internal.RemoteWorker, len(rebuiltWorkers))
+	for i := range rebuiltWorkers {
+		worker := &rebuiltWorkers[i]
+		rebuilt[workerKey{worker.EthAddress, worker.NodeType, worker.Region}] = worker
+	}
+
+	payouts, err := tx.GetPayouts("")
+	if err != nil {
+		return fmt.Errorf("failed to load payouts: %w", err)
+	}
+	for _, payout := range payouts {
+		if !payout.CountsAsPaid() {
+			continue
+		}
+		worker := matchPayout(rebuilt, payout)
+		if worker == nil {
+			report.UnmatchedPayouts = append(report.UnmatchedPayouts, payout)
+			continue
+		}
+		worker.PendingFees = worker.PendingFees.Sub(payout.Fees)
+		worker.PaidFees = worker.PaidFees.Add(payout.Fees)
+	}
+
+	currentWorkers, err := tx.GetRemoteWorkers()
+	if err != nil {
+		return fmt.Errorf("failed to load current workers: %w", err)
+	}
+	current := make(map[workerKey]internal.RemoteWorker, len(currentWorkers))
+	for _, worker := range currentWorkers {
+		current[workerKey{worker.EthAddress, worker.NodeType, worker.Region}] = worker
+	}
+
+	var changed []internal.RemoteWorker
+	for key, worker := range rebuilt {
+		if diff, ok := compareWorker(key, current[key], *worker); ok {
+			report.Diffs = append(report.Diffs, diff)
+			changed = append(changed, *worker)
+		}
+	}
+	for key, worker := range current {
+		if _, ok := rebuilt[key]; ok {
+			continue
+		}
+		// The worker does not appear in the event log at all.
+		cleared := internal.RemoteWorker{EthAddress: key.ethAddress, NodeType: key.nodeType, Region: key.region}
+		if diff, ok := compareWorker(key, worker, cleared); ok {
+			report.Diffs = append(report.Diffs, diff)
+			changed = append(changed, cleared)
+		}
+	}
+	sort.Slice(report.Diffs, func(i, j int) bool {
+		a, b := report.Diffs[i], report.Diffs[j]
+		if a.EthAddress != b.EthAddress {
+			return a.EthAddress < b.EthAddress
+		}
+		if a.NodeType != b.NodeType {
+			return a.NodeType < b.NodeType
+		}
+		return a.Region < b.Region
+	})
+
+	if apply && len(changed) > 0 {
+		if err := tx.SetWorkerBalances(changed); err != nil {
+			return fmt.Errorf("failed to apply rebuilt balances: %w", err)
+		}
+		report.Applied = true
+	}
+	return nil
+}
+
+// replayEventLog applies every event stored in tx to the scratch store in ID order. The job
+// correlations of a data source expire in event time, as during ingestion. Jobs whose fees
+// were recorded as unattributed stay unattributed even where the replay would still find
+// their correlation, so their reassignment, which is credited separately, is not counted
+// twice.
+func (p *DataLoaderPlugin) replayEventLog(tx internal.Store, scratch internal.Store, unattributed []internal.UnattributedFee, report *rebuildReport, rebuildLogger *log.Entry) error {
+	unattributedJobs := make(map[string]string, len(unattributed))
+	for _, fee := range unattributed {
+		unattributedJobs[fmt.Sprintf("%s/%d", fee.Source, fee.EventID)] = fee.RequestID
+	}
+
+	var afterID int64
+	for {
+		events, err := tx.GetEventLog(afterID, rebuildPageSize)
+		if err != nil {
+			return fmt.Errorf("failed to load event log: %w", err)
+		}
+		if len(events) == 0 {
+			return nil
+		}
+
+		for _, event := range events {
+			afterID = event.ID
+			eventLogger := rebuildLogger.WithFields(log.Fields{
+				"logID":   event.ID,
+				"source":  event.Source,
+				"eventID": event.EventID,
+			})
+
+			var parsedPayload struct {
+				EventType string          `json:"event_type"`
+				Payload   json.RawMessage `json:"Payload"`
+			}
+			if err := json.Unmarshal([]byte(event.Data), &parsedPayload); err != nil {
+				eventLogger.WithError(err).Warn("Skipping stored event with invalid payload")
+				report.EventsSkipped++
+				continue
+			}
+
+			source, nodeType := event.Source, event.NodeType
+			if source == "" {
+				// Events stored before data sources were recorded only name their node type
+				// in the payload, which was also the name of their data source.
+				var legacy struct {
+					NodeType string `json:"nodeType"`
+				}
+				if err := json.Unmarshal(parsedPayload.Payload, &legacy); err != nil || legacy.NodeType == "" {
+					eventLogger.WithField("eventType", parsedPayload.EventType).Warn("Skipping legacy event without a node type")
+					report.EventsSkipped++
+					continue
+				}
+				source, nodeType = legacy.NodeType, legacy.NodeType
+			}
+
+			eventTime := time.Unix(event.CreatedAt, 0).UTC()
+			if _, err := scratch.ExpireJobCorrelations(source, eventTime); err != nil {
+				return fmt.Errorf("failed to expire job correlations before event %d: %w", event.ID, err)
+			}
+			if requestID, ok := unattributedJobs[fmt.Sprintf("%s/%d", source, event.EventID)]; ok {
+				if _, err := scratch.TakeJobCorrelation(source, requestID); err != nil {
+					return fmt.Errorf("failed to drop job correlation of event %d: %w", event.ID, err)
+				}
+			}
+
+			mutation, err := p.eventMutation(internal.EventContext{
+				Source:    source,
+				NodeType:  nodeType,
+				Region:    p.region,
+				EventID:   event.EventID,
+				EventTime: eventTime,
+				Replay:    true,
+				Logger:    eventLogger,
+			}, parsedPayload.EventType, event.Version, parsedPayload.Payload)
+			if err != nil {
+				eventLogger.WithError(err).Warn("Skipping stored event that cannot be decoded")
+				report.EventsSkipped++
+				continue
+			}
+			if mutation != nil {
+				if err := mutation(scratch); err != nil {
+					return fmt.Errorf("failed to replay event %d: %w", event.ID, err)
+				}
+			}
+			report.EventsReplayed++
+		}
+	}
+}
+
+// matchPayout returns the rebuilt worker a payout was paid to. Payouts recorded before
+// they carried a node type and region are matched by address if that is unambiguous.
+func matchPayout(rebuilt map[workerKey]*internal.RemoteWorker, payout internal.PoolPayout) *internal.RemoteWorker {
+	if payout.NodeType != "" || payout.Region != "" {
+		return rebuilt[workerKey{payout.EthAddress, payout.NodeType, payout.Region}]
+	}
+	var match *internal.RemoteWorker
+	for key, worker := range rebuilt {
+		if key.ethAddress != payout.EthAddress {
+			continue
+		}
+		if match != nil {
+			return nil
+		}
+		match = worker
+	}
+	return match
+}
+
+// compareWorker returns the diff between the current and rebuilt state of a worker, and
+// whether they differ.
+func compareWorker(key workerKey, current internal.RemoteWorker, rebuilt internal.RemoteWorker) (balanceDiff, bool) {
+	diff := balanceDiff{
+		EthAddress:         key.ethAddress,
+		NodeType:           key.nodeType,
+		Region:             key.region,
+		CurrentPendingFees: current.PendingFees,
+		RebuiltPendingFees: rebuilt.PendingFees,
+		CurrentPaidFees:    current.PaidFees,
+		RebuiltPaidFees:    rebuilt.PaidFees,
+		CurrentConnected:   current.IsConnected,
+		RebuiltConnected:   rebuilt.IsConnected,
+	}
+	return diff, current.PendingFees.Cmp(rebuilt.PendingFees) != 0 ||
+		current.PaidFees.Cmp(rebuilt.PaidFees) != 0 ||
+		current.IsConnected != rebuilt.IsConnected
+}
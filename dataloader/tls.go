@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	"net/http"
+	"os"
+	"time"
+)
+
+// newHTTPClient creates the HTTP client of a data source with its timeout, trusted CAs,
+// client certificate and public key pins.
+func newHTTPClient(source internal.DataSource) (*http.Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if source.CACertPath != "" {
+		caPEM, err := os.ReadFile(source.CACertPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", source.CACertPath)
+		}
+		tlsConfig.RootCAs = roots
+	}
+
+	if source.ClientCertPath != "" || source.ClientKeyPath != "" {
+		cert, err := tls.LoadX509KeyPair(source.ClientCertPath, source.ClientKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(source.PinnedPublicKeys) > 0 {
+		pins := make(map[string]bool, len(source.PinnedPublicKeys))
+		for _, pin := range source.PinnedPublicKeys {
+			if decoded, err := base64.StdEncoding.DecodeString(pin); err != nil || len(decoded) != sha256.Size {
+				return nil, fmt.Errorf("invalid pinned public key %q", pin)
+			}
+			pins[pin] = true
+		}
+		// VerifyConnection runs after the regular chain verification, so pinning only
+		// narrows down which verified certificates are accepted.
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, chain := range cs.VerifiedChains {
+				for _, cert := range chain {
+					sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+					if pins[base64.StdEncoding.EncodeToString(sum[:])] {
+						return nil
+					}
+				}
+			}
+			return fmt.Errorf("no pinned public key in certificate chain of %s", cs.ServerName)
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(source.RequestTimeoutSeconds) * time.Second,
+	}, nil
+}
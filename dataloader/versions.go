@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+)
+
+// unversionedEvent is the envelope Version of orchestrators that do not set it. They
+// publish the version 1 payload schemas.
+const unversionedEvent = 0
+
+// envelopeVersion returns the payload schema version of an envelope.
+func envelopeVersion(version int) int {
+	if version == unversionedEvent {
+		return 1
+	}
+	return version
+}
+
+// The payload schemas of envelope version 1. Each one is decoded as published and
+// upgraded to the canonical payload the handlers work with, so a new go-livepeer schema
+// only needs its own structs and upgraders.
+
+type jobReceivedV1 struct {
+	EthAddress string `json:"ethAddress"`
+	ModelID    string `json:"modelID"`
+	NodeType   string `json:"nodeType"`
+	Pipeline   string `json:"pipeline"`
+	RequestID  string `json:"requestID"`
+	TaskID     int    `json:"taskID"`
+}
+
+type jobProcessedV1 struct {
+	ComputeUnits int `json:"computeUnits"`
+	// Fees is decoded as a number literal, since float64 cannot hold every wei amount.
+	Fees                json.Number `json:"fees"`
+	NodeType            string      `json:"nodeType"`
+	PricePerComputeUnit int         `json:"pricePerComputeUnit"`
+	RequestID           string      `json:"requestID"`
+	ResponseTime        int64       `json:"responseTime"`
+	EthAddress          string      `json:"ethAddress,omitempty"`
+	Pipeline            string      `json:"pipeline,omitempty"`
+	ModelID             string      `json:"modelID,omitempty"`
+}
+
+type orchestratorResetV1 struct{}
+
+type workerConnectedV1 struct {
+	Connection string `json:"connection"`
+	EthAddress string `json:"ethAddress"`
+	NodeType   string `json:"nodeType"`
+}
+
+type workerDisconnectedV1 struct {
+	EthAddress string `json:"ethAddress"`
+	NodeType   string `json:"nodeType"`
+}
+
+func upgradeJobReceivedV1(v jobReceivedV1) (JobReceived, error) {
+	return JobReceived(v), nil
+}
+
+func upgradeJobProcessedV1(v jobProcessedV1) (JobProcessed, error) {
+	var fees internal.Wei
+	if v.Fees != "" {
+		parsed, err := internal.ParseWei(v.Fees.String())
+		if err != nil {
+			return JobProcessed{}, err
+		}
+		fees = parsed
+	}
+	return JobProcessed{
+		ComputeUnits:        v.ComputeUnits,
+		Fees:                fees,
+		NodeType:            v.NodeType,
+		PricePerComputeUnit: v.PricePerComputeUnit,
+		RequestID:           v.RequestID,
+		ResponseTime:        v.ResponseTime,
+		EthAddress:          v.EthAddress,
+		Pipeline:            v.Pipeline,
+		ModelID:             v.ModelID,
+	}, nil
+}
+
+func upgradeOrchestratorResetV1(v orchestratorResetV1) (OrchestratorReset, error) {
+	return OrchestratorReset(v), nil
+}
+
+func upgradeWorkerConnectedV1(v workerConnectedV1) (WorkerConnected, error) {
+	return WorkerConnected(v), nil
+}
+
+func upgradeWorkerDisconnectedV1(v workerDisconnectedV1) (WorkerDisconnected, error) {
+	return WorkerDisconnected(v), nil
+}
+
+// versioned returns an event handler that decodes the payload schema V of one envelope
+// version, upgrades it to the canonical payload C and passes it to handle.
+func versioned[V any, C any](upgrade func(V) (C, error), handle func(ctx internal.EventContext, payload C) (func(tx internal.Store) error, error)) internal.EventHandler {
+	return func(ctx internal.EventContext, rawPayload json.RawMessage) (func(tx internal.Store) error, error) {
+		var payload V
+		if err := json.Unmarshal(rawPayload, &payload); err != nil {
+			return nil, err
+		}
+		canonical, err := upgrade(payload)
+		if err != nil {
+			return nil, err
+		}
+		return handle(ctx, canonical)
+	}
+}
@@ -0,0 +1,38 @@
+package internal
+
+import (
+	"crypto/subtle"
+	"github.com/Livepeer-Open-Pool/openpool-plugin/config"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+)
+
+// RequireAdmin only lets requests through that carry token as bearer token. All requests
+// are rejected when token is empty.
+func RequireAdmin(token string, logger *log.Entry, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if token == "" {
+			JSONError(w, "admin endpoints are disabled", http.StatusForbidden)
+			return
+		}
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			logger.WithFields(log.Fields{
+				"method": r.Method,
+				"path":   r.URL.Path,
+				"remote": r.RemoteAddr,
+			}).Warn("Rejected unauthorized admin request")
+			JSONError(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// ServesAdminRoutes reports whether cfg loads the API plugin. Plugins register their admin
+// routes on http.DefaultServeMux, which only the API plugin serves.
+func ServesAdminRoutes(cfg config.Config) bool {
+	return cfg.APIConfig != nil && cfg.APIConfig.PluginName != ""
+}
@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CommissionConfig is the commission schedule. Jobs that match no rule are credited with
+// PoolCommissionRate.
+type CommissionConfig struct {
+	// EffectiveFrom is the time the configured PoolCommissionRate and Rules take effect.
+	// When it is not set, changed settings take effect when the data loader starts.
+	EffectiveFrom *time.Time `json:"EffectiveFrom,omitempty"`
+	// VolumeWindowSeconds is the time window a worker's volume is summed over for the
+	// volume tiers. Defaults to DefaultCommissionVolumeWindowSeconds.
+	VolumeWindowSeconds int `json:"VolumeWindowSeconds,omitempty"`
+	// Rules are evaluated in order and the first one matching a job applies, so more
+	// specific rules go first.
+	Rules []CommissionRule `json:"Rules,omitempty"`
+}
+
+// DefaultCommissionVolumeWindowSeconds is used when VolumeWindowSeconds is not configured.
+const DefaultCommissionVolumeWindowSeconds = 30 * 86400
+
+// CommissionRule sets the rate of the jobs that match all of its non-empty criteria.
+type CommissionRule struct {
+	// Name identifies the rule on /status and in logs.
+	Name       string `json:"Name,omitempty"`
+	NodeType   string `json:"NodeType,omitempty"`
+	Pipeline   string `json:"Pipeline,omitempty"`
+	ModelID    string `json:"ModelID,omitempty"`
+	Region     string `json:"Region,omitempty"`
+	EthAddress string `json:"EthAddress,omitempty"`
+	// Rate has the meaning of PoolCommissionRate, the share of the fees credited to the worker.
+	Rate float64 `json:"Rate"`
+	// Tiers replace Rate for workers whose volume reached their MinVolume.
+	Tiers []CommissionTier `json:"Tiers,omitempty"`
+}
+
+// CommissionTier is the rate of a rule for workers with a minimum volume.
+type CommissionTier struct {
+	// MinVolume is the fees in wei of the jobs a worker processed within the volume window.
+	MinVolume Wei     `json:"MinVolume"`
+	Rate      float64 `json:"Rate"`
+}
+
+// CommissionJob is the processed job a commission rule is looked up for.
+type CommissionJob struct {
+	EthAddress string
+	NodeType   string
+	Region     string
+	Pipeline   string
+	ModelID    string
+}
+
+// VolumeWindow returns the time window volumes are summed over.
+func (c *CommissionConfig) VolumeWindow() time.Duration {
+	return time.Duration(c.VolumeWindowSeconds) * time.Second
+}
+
+// Rule returns the first rule matching a job, or nil if there is none.
+func (c *CommissionConfig) Rule(job CommissionJob) *CommissionRule {
+	for i := range c.Rules {
+		if c.Rules[i].Matches(job) {
+			return &c.Rules[i]
+		}
+	}
+	return nil
+}
+
+// Matches reports whether a job matches all criteria of the rule.
+func (r *CommissionRule) Matches(job CommissionJob) bool {
+	return (r.NodeType == "" || r.NodeType == job.NodeType) &&
+		(r.Pipeline == "" || r.Pipeline == job.Pipeline) &&
+		(r.ModelID == "" || r.ModelID == job.ModelID) &&
+		(r.Region == "" || r.Region == job.Region) &&
+		(r.EthAddress == "" || strings.EqualFold(r.EthAddress, job.EthAddress))
+}
+
+// BasisPoints returns the rate of the rule in basis points for a worker with the given
+// volume, which only matters if the rule has tiers.
+func (r *CommissionRule) BasisPoints(volume Wei) int64 {
+	rate := r.Rate
+	for _, tier := range r.Tiers {
+		if volume.Cmp(tier.MinVolume) < 0 {
+			break
+		}
+		rate = tier.Rate
+	}
+	return RateBasisPoints(rate)
+}
+
+// validate checks the rates and sorts the tiers by volume.
+func (c *CommissionConfig) validate() error {
+	for i := range c.Rules {
+		rule := &c.Rules[i]
+		if rule.Rate < 0 || rule.Rate > 1 {
+			return fmt.Errorf("commission rule %d: rate %v is not between 0 and 1", i, rule.Rate)
+		}
+		for _, tier := range rule.Tiers {
+			if tier.Rate < 0 || tier.Rate > 1 {
+				return fmt.Errorf("commission rule %d: tier rate %v is not between 0 and 1", i, tier.Rate)
+			}
+		}
+		sort.SliceStable(rule.Tiers, func(a, b int) bool {
+			return rule.Tiers[a].MinVolume.Cmp(rule.Tiers[b].MinVolume) < 0
+		})
+	}
+	return nil
+}
+
+// CommissionAt returns the period of history, sorted by EffectiveFrom, in effect at t. It
+// returns the first period for times before it and nil if history is empty.
+func CommissionAt(history []CommissionPeriod, t time.Time) *CommissionPeriod {
+	if len(history) == 0 {
+		return nil
+	}
+	i := sort.Search(len(history), func(i int) bool {
+		return history[i].EffectiveFrom.After(t)
+	})
+	if i == 0 {
+		return &history[0]
+	}
+	return &history[i-1]
+}
+
+// SameCommission reports whether two periods have the same rate and schedule.
+func (c *CommissionPeriod) SameCommission(o *CommissionPeriod) bool {
+	if c.Rate != o.Rate {
+		return false
+	}
+	a, errA := json.Marshal(c.Schedule)
+	b, errB := json.Marshal(o.Schedule)
+	return errA == nil && errB == nil && bytes.Equal(a, b)
+}
+
+// SumOperatorLedger returns the pool operator balances of ledger entries, sorted by region
+// and node type.
+func SumOperatorLedger(entries []OperatorLedgerEntry) []OperatorBalance {
+	byAccount := make(map[[2]string]*OperatorBalance)
+	var balances []*OperatorBalance
+	for _, entry := range entries {
+		key := [2]string{entry.Region, entry.NodeType}
+		balance, ok := byAccount[key]
+		if !ok {
+			balance = &OperatorBalance{Region: entry.Region, NodeType: entry.NodeType}
+			byAccount[key] = balance
+			balances = append(balances, balance)
+		}
+		if entry.Kind == OperatorWithdrawal {
+			balance.Withdrawn = balance.Withdrawn.Sub(entry.Amount)
+		} else {
+			balance.Accrued = balance.Accrued.Add(entry.Amount)
+		}
+		balance.Balance = balance.Balance.Add(entry.Amount)
+	}
+	sort.Slice(balances, func(i, j int) bool {
+		if balances[i].Region != balances[j].Region {
+			return balances[i].Region < balances[j].Region
+		}
+		return balances[i].NodeType < balances[j].NodeType
+	})
+	result := make([]OperatorBalance, len(balances))
+	for i, balance := range balances {
+		result[i] = *balance
+	}
+	return result
+}
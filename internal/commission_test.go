@@ -0,0 +1,46 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCommissionAt(t *testing.T) {
+	jan := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	feb := time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)
+	mar := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	history := []CommissionPeriod{
+		{ID: 1, EffectiveFrom: jan, Rate: 0.9},
+		{ID: 2, EffectiveFrom: feb, Rate: 0.8},
+		{ID: 3, EffectiveFrom: mar, Rate: 0.7},
+	}
+
+	tests := []struct {
+		name    string
+		history []CommissionPeriod
+		at      time.Time
+		wantID  int64
+	}{
+		{name: "empty history", history: nil, at: feb, wantID: 0},
+		{name: "before the first period", history: history, at: jan.Add(-time.Hour), wantID: 1},
+		{name: "at the first period", history: history, at: jan, wantID: 1},
+		{name: "within a period", history: history, at: feb.Add(-time.Second), wantID: 1},
+		{name: "at a later period", history: history, at: feb, wantID: 2},
+		{name: "after the last period", history: history, at: mar.AddDate(1, 0, 0), wantID: 3},
+		{name: "single period", history: history[1:2], at: jan, wantID: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := CommissionAt(tt.history, tt.at)
+			if tt.wantID == 0 {
+				if got != nil {
+					t.Errorf("CommissionAt(%v) = period %d, want none", tt.at, got.ID)
+				}
+				return
+			}
+			if got == nil || got.ID != tt.wantID {
+				t.Errorf("CommissionAt(%v) = %+v, want period %d", tt.at, got, tt.wantID)
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// configFileName is the config file the manager was started with. main sets it before
+// the plugins are loaded so they can read the settings that openpool-plugin does not know about.
+var configFileName string
+
+// SetConfigFileName records the config file the manager was started with.
+func SetConfigFileName(name string) {
+	configFileName = name
+}
+
+// Config holds the manager specific settings. They live in the same config file as the
+// shared openpool-plugin config.Config and are decoded from the same JSON sections.
+type Config struct {
+	// PoolCommissionRate is the shared config.Config setting. It is only decoded here to be
+	// checked before it is recorded in the commission history.
+	PoolCommissionRate     float64                 `json:"PoolCommissionRate"`
+	DataLoaderPluginConfig *DataLoaderPluginConfig `json:"DataLoaderPluginConfig,omitempty"`
+	APIConfig              *APIConfig              `json:"APIConfig,omitempty"`
+	WorkerFilter           *WorkerFilterConfig     `json:"WorkerFilter,omitempty"`
+	Commission             *CommissionConfig       `json:"Commission,omitempty"`
+	PayoutLoopConfig       *PayoutLoopConfig       `json:"PayoutLoopConfig,omitempty"`
+}
+
+// WorkerFilterConfig is the policy GetFilteredWorkers applies to connected workers, based on
+// their metrics over the last WindowSeconds. A zero limit is not checked.
+type WorkerFilterConfig struct {
+	// WindowSeconds is the time window the metrics are computed over.
+	// Defaults to DefaultWorkerFilterWindowSeconds.
+	WindowSeconds int `json:"WindowSeconds,omitempty"`
+	// MinJobs is the number of received jobs from which MaxFailureRate and
+	// MaxResponseTimeP95 are checked, so new workers are not filtered on too few jobs.
+	MinJobs int `json:"MinJobs,omitempty"`
+	// MaxFailureRate is the highest share of received jobs a worker may fail to process.
+	MaxFailureRate float64 `json:"MaxFailureRate,omitempty"`
+	// MaxResponseTimeP95 is the highest 95th percentile response time, in the unit of the
+	// job-processed responseTime.
+	MaxResponseTimeP95 int64 `json:"MaxResponseTimeP95,omitempty"`
+	// MinUptime is the lowest share of the window a worker must have been connected. It is
+	// not checked for workers without sessions in the window, which were connected before
+	// sessions were recorded.
+	MinUptime float64 `json:"MinUptime,omitempty"`
+}
+
+// DefaultWorkerFilterWindowSeconds is used when WindowSeconds is not configured.
+const DefaultWorkerFilterWindowSeconds = 86400
+
+// Window returns the time window the metrics are computed over.
+func (c *WorkerFilterConfig) Window() time.Duration {
+	return time.Duration(c.WindowSeconds) * time.Second
+}
+
+// Allows reports whether a worker with the given metrics passes the policy.
+func (c *WorkerFilterConfig) Allows(m WorkerMetrics) bool {
+	if c.MinUptime > 0 && m.Sessions > 0 && m.Uptime < c.MinUptime {
+		return false
+	}
+	if m.JobsProcessed+m.JobsFailed < c.MinJobs {
+		return true
+	}
+	if c.MaxFailureRate > 0 && m.FailureRate > c.MaxFailureRate {
+		return false
+	}
+	if c.MaxResponseTimeP95 > 0 && m.ResponseTimeP95 > c.MaxResponseTimeP95 {
+		return false
+	}
+	return true
+}
+
+// APIConfig extends the shared API settings.
+type APIConfig struct {
+	// AdminToken is the bearer token the /admin endpoints require. They are disabled when empty.
+	AdminToken string `json:"AdminToken,omitempty"`
+}
+
+// PayoutLoopConfig extends the shared payout loop settings.
+type PayoutLoopConfig struct {
+	// ConfirmationDepth is the number of blocks, including its own, a payout transaction
+	// needs before the payout is confirmed. Defaults to DefaultConfirmationDepth.
+	ConfirmationDepth int `json:"ConfirmationDepth,omitempty"`
+	// ReceiptPollSeconds is how often the receipts of submitted payouts are checked.
+	// Defaults to DefaultReceiptPollSeconds.
+	ReceiptPollSeconds int `json:"ReceiptPollSeconds,omitempty"`
+	// StuckTimeoutSeconds is how long a payout transaction may go unmined before it is
+	// replaced with a fee-bumped one. Defaults to DefaultStuckTimeoutSeconds.
+	StuckTimeoutSeconds int `json:"StuckTimeoutSeconds,omitempty"`
+	// FeeBumpPercent is how much a replacement raises the max fee and priority fee of the
+	// transaction it replaces. Nodes reject replacements below 10. Defaults to
+	// DefaultFeeBumpPercent.
+	FeeBumpPercent int `json:"FeeBumpPercent,omitempty"`
+	// MaxFeePerGas caps the max fee per gas in wei of payout transactions. Transactions are
+	// not sent while the base fee is above it. Defaults to DefaultMaxFeePerGas.
+	MaxFeePerGas Wei `json:"MaxFeePerGas"`
+	// MaxPriorityFeePerGas caps the priority fee per gas in wei of payout transactions.
+	// Defaults to DefaultMaxPriorityFeePerGas.
+	MaxPriorityFeePerGas Wei `json:"MaxPriorityFeePerGas"`
+	// MaxGasLimit caps the gas estimated for a payout transaction. Defaults to
+	// DefaultMaxGasLimit.
+	MaxGasLimit uint64 `json:"MaxGasLimit,omitempty"`
+	// DisperseContract is the address of a contract with disperseEther(address[],uint256[])
+	// that pays the workers of a cycle in batched transactions. Each worker is paid in its
+	// own transaction when empty.
+	DisperseContract string `json:"DisperseContract,omitempty"`
+	// MaxBatchSize is the most workers paid in one batched transaction. Defaults to
+	// DefaultMaxBatchSize.
+	MaxBatchSize int `json:"MaxBatchSize,omitempty"`
+}
+
+// Defaults of the payout tracking settings.
+const (
+	DefaultConfirmationDepth   = 12
+	DefaultReceiptPollSeconds  = 30
+	DefaultStuckTimeoutSeconds = 600
+	DefaultFeeBumpPercent      = 20
+)
+
+// Defaults of the payout transaction fee limits.
+const (
+	DefaultMaxFeePerGas         = 5_000_000_000_000
+	DefaultMaxPriorityFeePerGas = 2_000_000_000
+	DefaultMaxGasLimit          = 1_000_000
+)
+
+// DefaultMaxBatchSize is used when MaxBatchSize is not configured.
+const DefaultMaxBatchSize = 50
+
+// DataLoaderPluginConfig extends the shared data loader settings.
+type DataLoaderPluginConfig struct {
+	// PushListenAddress is the address (e.g. ":8090") the push ingestion endpoint listens on.
+	// Push ingestion is disabled when empty.
+	PushListenAddress string `json:"PushListenAddress,omitempty"`
+	// JobCorrelationTTLSeconds is how long a job-received event waits for its job-processed
+	// event before the correlation is dropped. Defaults to DefaultJobCorrelationTTLSeconds.
+	JobCorrelationTTLSeconds int `json:"JobCorrelationTTLSeconds,omitempty"`
+	// MaxApplyAttempts is the number of times in a row an event may fail to be stored before
+	// it is moved to the dead letters. Defaults to DefaultMaxApplyAttempts.
+	MaxApplyAttempts int `json:"MaxApplyAttempts,omitempty"`
+	// HandlerPlugins are event handler plugins in PluginPath that add handlers for event
+	// types the data loader does not know.
+	HandlerPlugins []string     `json:"HandlerPlugins,omitempty"`
+	DataSources    []DataSource `json:"Datasources"`
+}
+
+// DefaultJobCorrelationTTLSeconds is used when JobCorrelationTTLSeconds is not configured.
+const DefaultJobCorrelationTTLSeconds = 3600
+
+// DefaultMaxApplyAttempts is used when MaxApplyAttempts is not configured.
+const DefaultMaxApplyAttempts = 3
+
+// Defaults of the data source request and circuit breaker settings.
+const (
+	DefaultRequestTimeoutSeconds         = 30
+	DefaultMaxRetries                    = 3
+	DefaultRetryBackoffMilliseconds      = 1000
+	DefaultMaxRetryBackoffMilliseconds   = 30000
+	DefaultCircuitBreakerThreshold       = 5
+	DefaultCircuitBreakerCooldownSeconds = 300
+)
+
+// Data source types.
+const (
+	DataSourceTypeHTTP = "http"
+	DataSourceTypeFile = "file"
+)
+
+// DataSource extends the shared data source settings.
+type DataSource struct {
+	// Name identifies the data source, e.g. in push URLs and stored cursors. It defaults to
+	// NodeType and must be set when several data sources share a node type.
+	Name string `json:"Name,omitempty"`
+	// Type is DataSourceTypeHTTP (the default) to poll Endpoint, or DataSourceTypeFile to
+	// read the events from Path.
+	Type     string `json:"Type,omitempty"`
+	Endpoint string `json:"Endpoint"`
+	// Path is a JSON file with an array of event envelopes, a JSONL file with one envelope
+	// per line, or a directory of such files, for DataSourceTypeFile.
+	Path string `json:"Path,omitempty"`
+	// EventSource is the name of the data source whose orchestrator the events of a
+	// DataSourceTypeFile data source come from. They are stored and deduplicated under that
+	// name, so the events it already ingested are not credited again. Required for file
+	// data sources; a file of events no data source ingests can use the file source's Name.
+	EventSource string `json:"EventSource,omitempty"`
+	NodeType    string `json:"NodeType"`
+	// FetchIntervalSeconds overrides the data loader's FetchIntervalSeconds for this data source.
+	FetchIntervalSeconds int `json:"FetchIntervalSeconds,omitempty"`
+	// RequestTimeoutSeconds bounds a single request to the endpoint.
+	RequestTimeoutSeconds int `json:"RequestTimeoutSeconds,omitempty"`
+	// MaxRetries is the number of times a failed fetch is retried within one fetch cycle,
+	// waiting RetryBackoffMilliseconds before the first retry and doubling the wait up to
+	// MaxRetryBackoffMilliseconds. A negative MaxRetries disables retries.
+	MaxRetries                  int `json:"MaxRetries,omitempty"`
+	RetryBackoffMilliseconds    int `json:"RetryBackoffMilliseconds,omitempty"`
+	MaxRetryBackoffMilliseconds int `json:"MaxRetryBackoffMilliseconds,omitempty"`
+	// CircuitBreakerThreshold is the number of consecutive failed fetch cycles after which
+	// the data source is not fetched for CircuitBreakerCooldownSeconds.
+	CircuitBreakerThreshold       int `json:"CircuitBreakerThreshold,omitempty"`
+	CircuitBreakerCooldownSeconds int `json:"CircuitBreakerCooldownSeconds,omitempty"`
+	// PushSecret is the HMAC-SHA256 key the orchestrator signs pushed events with.
+	// Pushes for a data source without a secret are rejected. File data sources cannot have one.
+	PushSecret string `json:"PushSecret,omitempty"`
+	// BearerToken is sent as "Authorization: Bearer <token>" with every request.
+	BearerToken string `json:"BearerToken,omitempty"`
+	// BasicAuthUsername and BasicAuthPassword are sent as basic auth instead of a bearer token.
+	BasicAuthUsername string `json:"BasicAuthUsername,omitempty"`
+	BasicAuthPassword string `json:"BasicAuthPassword,omitempty"`
+	// CACertPath is a PEM bundle of the CAs trusted for the endpoint instead of the system roots.
+	CACertPath string `json:"CACertPath,omitempty"`
+	// ClientCertPath and ClientKeyPath are the PEM client certificate and key for mutual TLS.
+	ClientCertPath string `json:"ClientCertPath,omitempty"`
+	ClientKeyPath  string `json:"ClientKeyPath,omitempty"`
+	// PinnedPublicKeys are base64 SHA-256 hashes of subject public keys. When set, the
+	// endpoint's verified certificate chain must contain one of them.
+	PinnedPublicKeys []string `json:"PinnedPublicKeys,omitempty"`
+}
+
+// LoadConfig reads the manager specific settings from the config file set with SetConfigFileName.
+func LoadConfig() (*Config, error) {
+	if configFileName == "" {
+		return nil, fmt.Errorf("config file name is not set")
+	}
+	file, err := os.Open(configFileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var cfg Config
+	if err := json.NewDecoder(file).Decode(&cfg); err != nil {
+		return nil, err
+	}
+	if cfg.DataLoaderPluginConfig == nil {
+		cfg.DataLoaderPluginConfig = &DataLoaderPluginConfig{}
+	}
+	if cfg.APIConfig == nil {
+		cfg.APIConfig = &APIConfig{}
+	}
+	if cfg.PayoutLoopConfig == nil {
+		cfg.PayoutLoopConfig = &PayoutLoopConfig{}
+	}
+	if cfg.PayoutLoopConfig.ConfirmationDepth <= 0 {
+		cfg.PayoutLoopConfig.ConfirmationDepth = DefaultConfirmationDepth
+	}
+	if cfg.PayoutLoopConfig.ReceiptPollSeconds <= 0 {
+		cfg.PayoutLoopConfig.ReceiptPollSeconds = DefaultReceiptPollSeconds
+	}
+	if cfg.PayoutLoopConfig.StuckTimeoutSeconds <= 0 {
+		cfg.PayoutLoopConfig.StuckTimeoutSeconds = DefaultStuckTimeoutSeconds
+	}
+	if cfg.PayoutLoopConfig.FeeBumpPercent <= 0 {
+		cfg.PayoutLoopConfig.FeeBumpPercent = DefaultFeeBumpPercent
+	}
+	if cfg.PayoutLoopConfig.MaxFeePerGas.Sign() <= 0 {
+		cfg.PayoutLoopConfig.MaxFeePerGas = NewWei(DefaultMaxFeePerGas)
+	}
+	if cfg.PayoutLoopConfig.MaxPriorityFeePerGas.Sign() <= 0 {
+		cfg.PayoutLoopConfig.MaxPriorityFeePerGas = NewWei(DefaultMaxPriorityFeePerGas)
+	}
+	if cfg.PayoutLoopConfig.MaxGasLimit == 0 {
+		cfg.PayoutLoopConfig.MaxGasLimit = DefaultMaxGasLimit
+	}
+	if cfg.PayoutLoopConfig.MaxBatchSize <= 0 {
+		cfg.PayoutLoopConfig.MaxBatchSize = DefaultMaxBatchSize
+	}
+	if cfg.WorkerFilter == nil {
+		cfg.WorkerFilter = &WorkerFilterConfig{}
+	}
+	if cfg.WorkerFilter.WindowSeconds <= 0 {
+		cfg.WorkerFilter.WindowSeconds = DefaultWorkerFilterWindowSeconds
+	}
+	if cfg.Commission == nil {
+		cfg.Commission = &CommissionConfig{}
+	}
+	if cfg.Commission.VolumeWindowSeconds <= 0 {
+		cfg.Commission.VolumeWindowSeconds = DefaultCommissionVolumeWindowSeconds
+	}
+	if cfg.PoolCommissionRate < 0 || cfg.PoolCommissionRate > 1 {
+		return nil, fmt.Errorf("pool commission rate %v is not between 0 and 1", cfg.PoolCommissionRate)
+	}
+	if err := cfg.Commission.validate(); err != nil {
+		return nil, err
+	}
+	if cfg.DataLoaderPluginConfig.JobCorrelationTTLSeconds <= 0 {
+		cfg.DataLoaderPluginConfig.JobCorrelationTTLSeconds = DefaultJobCorrelationTTLSeconds
+	}
+	if cfg.DataLoaderPluginConfig.MaxApplyAttempts <= 0 {
+		cfg.DataLoaderPluginConfig.MaxApplyAttempts = DefaultMaxApplyAttempts
+	}
+	for i := range cfg.DataLoaderPluginConfig.DataSources {
+		cfg.DataLoaderPluginConfig.DataSources[i].setDefaults()
+	}
+	return &cfg, nil
+}
+
+// setDefaults fills in the request and circuit breaker settings that are not configured.
+func (ds *DataSource) setDefaults() {
+	if ds.Type == "" {
+		ds.Type = DataSourceTypeHTTP
+	}
+	if ds.RequestTimeoutSeconds <= 0 {
+		ds.RequestTimeoutSeconds = DefaultRequestTimeoutSeconds
+	}
+	if ds.MaxRetries < 0 {
+		ds.MaxRetries = 0
+	} else if ds.MaxRetries == 0 {
+		ds.MaxRetries = DefaultMaxRetries
+	}
+	if ds.RetryBackoffMilliseconds <= 0 {
+		ds.RetryBackoffMilliseconds = DefaultRetryBackoffMilliseconds
+	}
+	if ds.MaxRetryBackoffMilliseconds <= 0 {
+		ds.MaxRetryBackoffMilliseconds = DefaultMaxRetryBackoffMilliseconds
+	}
+	if ds.CircuitBreakerThreshold <= 0 {
+		ds.CircuitBreakerThreshold = DefaultCircuitBreakerThreshold
+	}
+	if ds.CircuitBreakerCooldownSeconds <= 0 {
+		ds.CircuitBreakerCooldownSeconds = DefaultCircuitBreakerCooldownSeconds
+	}
+}
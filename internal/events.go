@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"sync"
+	"time"
+)
+
+// EventContext describes the event a handler is called for.
+type EventContext struct {
+	// Source is the name of the data source the event was ingested from.
+	Source    string
+	NodeType  string
+	Region    string
+	EventID   int64
+	EventTime time.Time
+	// Replay is set while stored events are replayed into a scratch store. Handlers should
+	// not report metrics or other side effects for replayed events.
+	Replay bool
+	Logger *log.Entry
+}
+
+// EventHandler decodes the payload of an event and returns the change it makes to the pool
+// state, or nil if the event does not change it. The returned function is called with a
+// store bound to the transaction that also records the event. An error means the payload
+// is invalid; the event is then recorded without changing the pool state.
+type EventHandler func(ctx EventContext, payload json.RawMessage) (func(tx Store) error, error)
+
+// AnyVersion registers a handler for every envelope version that has no handler of its own.
+const AnyVersion = 0
+
+// EventHandlerPlugin is implemented by the PluginInstance of event handler plugins, which
+// add handlers for event types the data loader does not know.
+type EventHandlerPlugin interface {
+	RegisterEventHandlers(registry *EventRegistry) error
+}
+
+// eventHandlerKey identifies a registered handler.
+type eventHandlerKey struct {
+	eventType string
+	version   int
+}
+
+// EventRegistry maps event types and envelope versions to their handlers.
+type EventRegistry struct {
+	mu       sync.RWMutex
+	handlers map[eventHandlerKey]EventHandler
+}
+
+// NewEventRegistry returns an empty registry.
+func NewEventRegistry() *EventRegistry {
+	return &EventRegistry{handlers: make(map[eventHandlerKey]EventHandler)}
+}
+
+// Register adds the handler of an event type and envelope version. It fails if a handler
+// is already registered for them.
+func (r *EventRegistry) Register(eventType string, version int, handler EventHandler) error {
+	if eventType == "" || handler == nil {
+		return fmt.Errorf("event type and handler are required")
+	}
+	if version < AnyVersion {
+		return fmt.Errorf("invalid version %d for event type %s", version, eventType)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := eventHandlerKey{eventType, version}
+	if _, exists := r.handlers[key]; exists {
+		return fmt.Errorf("a handler for event type %s version %d is already registered", eventType, version)
+	}
+	r.handlers[key] = handler
+	return nil
+}
+
+// Lookup returns the handler of an event type and envelope version, falling back to the
+// handler registered for AnyVersion.
+func (r *EventRegistry) Lookup(eventType string, version int) (EventHandler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if handler, ok := r.handlers[eventHandlerKey{eventType, version}]; ok {
+		return handler, true
+	}
+	handler, ok := r.handlers[eventHandlerKey{eventType, AnyVersion}]
+	return handler, ok
+}
+
+// Versions returns the envelope versions an event type has its own handler for, sorted.
+func (r *EventRegistry) Versions(eventType string) []int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var versions []int
+	for key := range r.handlers {
+		if key.eventType == eventType && key.version != AnyVersion {
+			versions = append(versions, key.version)
+		}
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+// EventTypes returns the event types that have at least one handler, sorted.
+func (r *EventRegistry) EventTypes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	seen := make(map[string]bool)
+	var eventTypes []string
+	for key := range r.handlers {
+		if !seen[key.eventType] {
+			seen[key.eventType] = true
+			eventTypes = append(eventTypes, key.eventType)
+		}
+	}
+	sort.Strings(eventTypes)
+	return eventTypes
+}
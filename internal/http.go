@@ -0,0 +1,18 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// JSONError replies with code and a {"error": message} body. Unlike http.Error, it
+// keeps the application/json content type, and message is encoded so it may quote
+// anything.
+func JSONError(w http.ResponseWriter, message string, code int) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
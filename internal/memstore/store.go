@@ -0,0 +1,1099 @@
+// Package memstore is the in-memory storage of the test-storage plugin. It is a package of
+// its own so that the other plugins can be tested against it.
+package memstore
+
+import (
+	"fmt"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	"github.com/Livepeer-Open-Pool/openpool-plugin/config"
+	"github.com/Livepeer-Open-Pool/openpool-plugin/models"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	pool "github.com/Livepeer-Open-Pool/openpool-plugin"
+)
+
+// Ensure StoragePlugin implements pool.StorageInterface ✅
+var _ pool.StorageInterface = &InMemoryStorage{}
+var _ internal.Store = &InMemoryStorage{}
+
+type InMemoryStorage struct {
+	mu      sync.RWMutex
+	events  []models.PoolEvent
+	workers map[string]internal.RemoteWorker
+	payouts []internal.PoolPayout
+	cursors map[string]internal.DataSourceCursor
+	// seen holds the source event keys that were already stored.
+	seen         map[string]bool
+	eventLog     []internal.EventLog
+	correlations map[string]internal.JobCorrelation
+	unattributed []internal.UnattributedFee
+	deadLetters  []internal.DeadLetter
+	jobs         []internal.ProcessedJob
+	failures     []internal.JobFailure
+	commission   []internal.CommissionPeriod
+	operator     []internal.OperatorLedgerEntry
+	workerFilter *internal.WorkerFilterConfig
+	sessions     []internal.WorkerSession
+	health       map[string]internal.DataSourceHealth
+}
+
+// NewInMemoryStorage returns a new in-memory storage instance.
+func NewInMemoryStorage() pool.StorageInterface {
+	return &InMemoryStorage{
+		workers:      make(map[string]internal.RemoteWorker),
+		cursors:      make(map[string]internal.DataSourceCursor),
+		seen:         make(map[string]bool),
+		correlations: make(map[string]internal.JobCorrelation),
+		health:       make(map[string]internal.DataSourceHealth),
+	}
+}
+
+// Init can be used to initialize configuration if needed.
+func (s *InMemoryStorage) Init(cfg *config.Config) {
+	// The exported PluginInstance is a zero value, so make sure the maps exist.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.workers == nil {
+		s.workers = make(map[string]internal.RemoteWorker)
+	}
+	if s.cursors == nil {
+		s.cursors = make(map[string]internal.DataSourceCursor)
+	}
+	if s.seen == nil {
+		s.seen = make(map[string]bool)
+	}
+	if s.correlations == nil {
+		s.correlations = make(map[string]internal.JobCorrelation)
+	}
+	if s.health == nil {
+		s.health = make(map[string]internal.DataSourceHealth)
+	}
+	// The demo store filters on connection only when there is no config file.
+	if extCfg, err := internal.LoadConfig(); err == nil {
+		s.workerFilter = extCfg.WorkerFilter
+	}
+}
+
+// AddEvent stores an event in-memory.
+func (s *InMemoryStorage) AddEvent(event models.PoolEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+	return nil
+}
+
+// ApplyEvent stores an event ingested from a data source unless it was already stored and
+// then calls apply. apply runs against a copy of the store that replaces it only if apply
+// succeeds, so a failed event leaves nothing behind, like a rolled back transaction. The
+// store is locked meanwhile.
+func (s *InMemoryStorage) ApplyEvent(event *internal.EventLog, apply func(tx internal.Store) error) (bool, error) {
+	key := fmt.Sprintf("%s/%d", event.Source, event.EventID)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.seen[key] {
+		return false, nil
+	}
+
+	tx := s.copyState()
+	tx.seen[key] = true
+	stored := *event
+	stored.ID = int64(len(tx.eventLog) + 1)
+	tx.eventLog = append(tx.eventLog, stored)
+	tx.events = append(tx.events, models.DefaultPoolEvent{
+		Timestamp: event.CreatedAt,
+		Data:      event.Data,
+		Type:      event.Type,
+	})
+	if err := apply(tx); err != nil {
+		return false, err
+	}
+	s.setState(tx)
+	return true, nil
+}
+
+// Transaction calls fn with a copy of the store that replaces it if fn succeeds. The store
+// is locked meanwhile.
+func (s *InMemoryStorage) Transaction(fn func(tx internal.Store) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := s.copyState()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	s.setState(tx)
+	return nil
+}
+
+// copyState returns a store holding a copy of the data of s. The caller holds s.mu.
+func (s *InMemoryStorage) copyState() *InMemoryStorage {
+	return &InMemoryStorage{
+		events:       slices.Clone(s.events),
+		workers:      maps.Clone(s.workers),
+		payouts:      slices.Clone(s.payouts),
+		cursors:      maps.Clone(s.cursors),
+		seen:         maps.Clone(s.seen),
+		eventLog:     slices.Clone(s.eventLog),
+		correlations: maps.Clone(s.correlations),
+		unattributed: slices.Clone(s.unattributed),
+		deadLetters:  slices.Clone(s.deadLetters),
+		jobs:         slices.Clone(s.jobs),
+		failures:     slices.Clone(s.failures),
+		commission:   slices.Clone(s.commission),
+		operator:     slices.Clone(s.operator),
+		workerFilter: s.workerFilter,
+		sessions:     slices.Clone(s.sessions),
+		health:       maps.Clone(s.health),
+	}
+}
+
+// setState replaces the data of s with the data of tx. The caller holds s.mu.
+func (s *InMemoryStorage) setState(tx *InMemoryStorage) {
+	s.events = tx.events
+	s.workers = tx.workers
+	s.payouts = tx.payouts
+	s.cursors = tx.cursors
+	s.seen = tx.seen
+	s.eventLog = tx.eventLog
+	s.correlations = tx.correlations
+	s.unattributed = tx.unattributed
+	s.deadLetters = tx.deadLetters
+	s.jobs = tx.jobs
+	s.failures = tx.failures
+	s.commission = tx.commission
+	s.operator = tx.operator
+	s.sessions = tx.sessions
+	s.health = tx.health
+}
+
+// GetLastEventTimestamp returns the latest event timestamp.
+func (s *InMemoryStorage) GetLastEventTimestamp() (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var maxTimestamp int64
+	for _, event := range s.events {
+		if event.GetTimestamp() > maxTimestamp {
+			maxTimestamp = event.GetTimestamp()
+		}
+	}
+
+	if maxTimestamp == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(maxTimestamp, 0), nil
+}
+
+// NewScratchStore returns an empty in-memory store.
+func (s *InMemoryStorage) NewScratchStore() (internal.Store, error) {
+	scratch := &InMemoryStorage{}
+	scratch.Init(nil)
+	return scratch, nil
+}
+
+// GetEventLog returns up to limit stored source events with an ID above afterID, in ID order.
+func (s *InMemoryStorage) GetEventLog(afterID int64, limit int) ([]internal.EventLog, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var events []internal.EventLog
+	for _, event := range s.eventLog {
+		if event.ID > afterID && len(events) < limit {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// GetRemoteWorkers returns all workers with their balances and connection state.
+func (s *InMemoryStorage) GetRemoteWorkers() ([]internal.RemoteWorker, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	workers := make([]internal.RemoteWorker, 0, len(s.workers))
+	for _, worker := range s.workers {
+		workers = append(workers, worker)
+	}
+	return workers, nil
+}
+
+// SetWorkerBalances overwrites the balances and connection state of the given workers,
+// creating the ones that do not exist.
+func (s *InMemoryStorage) SetWorkerBalances(workers []internal.RemoteWorker) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, worker := range workers {
+		s.workers[workerKey(worker.EthAddress, worker.Region, worker.NodeType)] = worker
+	}
+	return nil
+}
+
+// GetPayouts returns the payouts with a status, or all payouts if status is empty, in the
+// order they were recorded.
+func (s *InMemoryStorage) GetPayouts(status string) ([]internal.PoolPayout, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var payouts []internal.PoolPayout
+	for _, payout := range s.payouts {
+		if status == "" || payout.Status == status {
+			payouts = append(payouts, payout)
+		}
+	}
+	return payouts, nil
+}
+
+// GetCursor returns the ingestion cursor of a data source, or nil if none was stored yet.
+func (s *InMemoryStorage) GetCursor(source string) (*internal.DataSourceCursor, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cursor, exists := s.cursors[source]
+	if !exists {
+		return nil, nil
+	}
+	return &cursor, nil
+}
+
+// SetCursor stores the ingestion cursor of a data source.
+func (s *InMemoryStorage) SetCursor(cursor internal.DataSourceCursor) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cursor.UpdatedAt = time.Now()
+	s.cursors[cursor.Source] = cursor
+	return nil
+}
+
+// GetDataSourceHealth returns the stored health of all data sources.
+func (s *InMemoryStorage) GetDataSourceHealth() ([]internal.DataSourceHealth, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	health := make([]internal.DataSourceHealth, 0, len(s.health))
+	for _, h := range s.health {
+		health = append(health, h)
+	}
+	sort.Slice(health, func(i, j int) bool { return health[i].Source < health[j].Source })
+	return health, nil
+}
+
+// SetDataSourceHealth stores the health of a data source.
+func (s *InMemoryStorage) SetDataSourceHealth(health internal.DataSourceHealth) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.health[health.Source] = health
+	return nil
+}
+
+// OpenWorkerSession marks a worker as connected to the orchestrator of a data source,
+// ending the session it may still have there.
+func (s *InMemoryStorage) OpenWorkerSession(session internal.WorkerSession) error {
+	s.mu.Lock()
+	s.closeSessions(session.ConnectedAt, func(open internal.WorkerSession) bool {
+		return open.Source == session.Source && open.EthAddress == session.EthAddress
+	})
+	session.ID = int64(len(s.sessions) + 1)
+	s.sessions = append(s.sessions, session)
+	s.mu.Unlock()
+
+	return s.UpdateWorkerStatus(session.EthAddress, true, session.Region, session.NodeType)
+}
+
+// CloseWorkerSession ends a worker's session on a data source. The worker stays connected
+// while it has sessions on other data sources.
+func (s *InMemoryStorage) CloseWorkerSession(source string, ethAddress string, region string, nodeType string, at time.Time) error {
+	s.mu.Lock()
+	s.closeSessions(at, func(open internal.WorkerSession) bool {
+		return open.Source == source && open.EthAddress == ethAddress
+	})
+	connected := s.hasOpenSession(ethAddress, region, nodeType)
+	s.mu.Unlock()
+
+	return s.UpdateWorkerStatus(ethAddress, connected, region, nodeType)
+}
+
+// CloseSourceSessions ends all sessions on a data source, e.g. when its orchestrator resets.
+func (s *InMemoryStorage) CloseSourceSessions(source string, region string, nodeType string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closeSessions(at, func(open internal.WorkerSession) bool {
+		return open.Source == source
+	})
+	for key, worker := range s.workers {
+		if worker.Region == region && worker.NodeType == nodeType && worker.IsConnected && !s.hasOpenSession(worker.EthAddress, region, nodeType) {
+			worker.IsConnected = false
+			s.workers[key] = worker
+		}
+	}
+	return nil
+}
+
+// closeSessions ends the open sessions matching match. The caller must hold s.mu.
+func (s *InMemoryStorage) closeSessions(at time.Time, match func(open internal.WorkerSession) bool) {
+	for i := range s.sessions {
+		if s.sessions[i].DisconnectedAt == nil && match(s.sessions[i]) {
+			disconnectedAt := at
+			s.sessions[i].DisconnectedAt = &disconnectedAt
+		}
+	}
+}
+
+// hasOpenSession reports whether a worker has an open session. The caller must hold s.mu.
+func (s *InMemoryStorage) hasOpenSession(ethAddress string, region string, nodeType string) bool {
+	for _, session := range s.sessions {
+		if session.EthAddress == ethAddress && session.Region == region && session.NodeType == nodeType && session.DisconnectedAt == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// AddJobCorrelation stores the worker a job was received by.
+func (s *InMemoryStorage) AddJobCorrelation(correlation internal.JobCorrelation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.correlations[correlation.Source+"/"+correlation.RequestID] = correlation
+	return nil
+}
+
+// TakeJobCorrelation removes and returns the correlation of a job, or nil if there is none.
+func (s *InMemoryStorage) TakeJobCorrelation(source string, requestID string) (*internal.JobCorrelation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := source + "/" + requestID
+	correlation, exists := s.correlations[key]
+	if !exists {
+		return nil, nil
+	}
+	delete(s.correlations, key)
+	return &correlation, nil
+}
+
+// AddUnattributedFees records fees that could not be attributed to a worker.
+func (s *InMemoryStorage) AddUnattributedFees(fee internal.UnattributedFee) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fee.ID = int64(len(s.unattributed) + 1)
+	fee.CreatedAt = time.Now()
+	s.unattributed = append(s.unattributed, fee)
+	return nil
+}
+
+// GetUnattributedFees returns the unattributed fees, including the reassigned ones if all is set.
+func (s *InMemoryStorage) GetUnattributedFees(all bool) ([]internal.UnattributedFee, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var fees []internal.UnattributedFee
+	for _, fee := range s.unattributed {
+		if all || fee.ReassignedTo == "" {
+			fees = append(fees, fee)
+		}
+	}
+	return fees, nil
+}
+
+// ReassignUnattributedFees credits unattributed fees to a worker's pending fees, or to the
+// pool operator if ethAddress is internal.PoolOperator.
+func (s *InMemoryStorage) ReassignUnattributedFees(id int64, ethAddress string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id < 1 || id > int64(len(s.unattributed)) {
+		return fmt.Errorf("unattributed fees %d not found", id)
+	}
+	fee := &s.unattributed[id-1]
+	if fee.ReassignedTo != "" {
+		return fmt.Errorf("unattributed fees %d were already reassigned to %s", id, fee.ReassignedTo)
+	}
+	now := time.Now()
+	fee.ReassignedTo = ethAddress
+	fee.ReassignedAt = &now
+
+	if ethAddress == internal.PoolOperator {
+		s.addOperatorLedgerEntry(internal.OperatorLedgerEntry{
+			Kind:      internal.OperatorReassigned,
+			Region:    fee.Region,
+			NodeType:  fee.NodeType,
+			Amount:    fee.Fees,
+			Source:    fee.Source,
+			EventID:   fee.EventID,
+			RequestID: fee.RequestID,
+		})
+		return nil
+	}
+	s.addPendingFeesWei(ethAddress, fee.Fees, fee.Region, fee.NodeType)
+	return nil
+}
+
+// AddProcessedJob records a processed job.
+func (s *InMemoryStorage) AddProcessedJob(job internal.ProcessedJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job.ID = int64(len(s.jobs) + 1)
+	s.jobs = append(s.jobs, job)
+	return nil
+}
+
+// GetProcessedJobs returns the processed jobs matching filter, newest first.
+func (s *InMemoryStorage) GetProcessedJobs(filter internal.JobFilter) ([]internal.ProcessedJob, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var jobs []internal.ProcessedJob
+	for _, job := range s.jobs {
+		if (filter.EthAddress == "" || job.EthAddress == filter.EthAddress) &&
+			(filter.NodeType == "" || job.NodeType == filter.NodeType) &&
+			(filter.Pipeline == "" || job.Pipeline == filter.Pipeline) &&
+			(filter.ModelID == "" || job.ModelID == filter.ModelID) &&
+			(filter.From == nil || !job.ProcessedAt.Before(*filter.From)) &&
+			(filter.To == nil || job.ProcessedAt.Before(*filter.To)) {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if !jobs[i].ProcessedAt.Equal(jobs[j].ProcessedAt) {
+			return jobs[i].ProcessedAt.After(jobs[j].ProcessedAt)
+		}
+		return jobs[i].ID > jobs[j].ID
+	})
+	if filter.Offset > 0 {
+		jobs = jobs[min(filter.Offset, len(jobs)):]
+	}
+	if filter.Limit > 0 && len(jobs) > filter.Limit {
+		jobs = jobs[:filter.Limit]
+	}
+	return jobs, nil
+}
+
+// AddCommissionPeriod stores a commission period, replacing the one with the same
+// EffectiveFrom.
+func (s *InMemoryStorage) AddCommissionPeriod(period internal.CommissionPeriod) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.commission {
+		if s.commission[i].EffectiveFrom.Equal(period.EffectiveFrom) {
+			s.commission[i].Rate = period.Rate
+			s.commission[i].Schedule = period.Schedule
+			return nil
+		}
+	}
+	period.ID = int64(len(s.commission) + 1)
+	period.CreatedAt = time.Now()
+	s.commission = append(s.commission, period)
+	sort.SliceStable(s.commission, func(i, j int) bool {
+		return s.commission[i].EffectiveFrom.Before(s.commission[j].EffectiveFrom)
+	})
+	return nil
+}
+
+// GetCommissionHistory returns the commission periods sorted by EffectiveFrom.
+func (s *InMemoryStorage) GetCommissionHistory() ([]internal.CommissionPeriod, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	history := make([]internal.CommissionPeriod, len(s.commission))
+	copy(history, s.commission)
+	return history, nil
+}
+
+// GetWorkerVolume returns the fees of the jobs a worker processed from (inclusive) to
+// (exclusive).
+func (s *InMemoryStorage) GetWorkerVolume(ethAddress string, from time.Time, to time.Time) (internal.Wei, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var volume internal.Wei
+	for _, job := range s.jobs {
+		if job.EthAddress == ethAddress && !job.ProcessedAt.Before(from) && job.ProcessedAt.Before(to) {
+			volume = volume.Add(job.Fees)
+		}
+	}
+	return volume, nil
+}
+
+// AddDeadLetter records an event that could not be parsed or applied. Recording the same
+// source event again replaces its envelope and error and counts another attempt.
+func (s *InMemoryStorage) AddDeadLetter(letter internal.DeadLetter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for i := range s.deadLetters {
+		existing := &s.deadLetters[i]
+		if existing.Source == letter.Source && existing.EventID == letter.EventID {
+			existing.NodeType = letter.NodeType
+			existing.Envelope = letter.Envelope
+			existing.Error = letter.Error
+			existing.Attempts++
+			existing.UpdatedAt = now
+			existing.ResolvedAt = nil
+			return nil
+		}
+	}
+	letter.ID = int64(len(s.deadLetters) + 1)
+	letter.Attempts = 1
+	letter.CreatedAt = now
+	letter.UpdatedAt = now
+	s.deadLetters = append(s.deadLetters, letter)
+	return nil
+}
+
+// GetDeadLetters returns the dead letters, including the resolved ones if all is set.
+func (s *InMemoryStorage) GetDeadLetters(all bool) ([]internal.DeadLetter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var letters []internal.DeadLetter
+	for _, letter := range s.deadLetters {
+		if all || letter.ResolvedAt == nil {
+			letters = append(letters, letter)
+		}
+	}
+	return letters, nil
+}
+
+// GetDeadLetter returns a dead letter, or nil if it does not exist.
+func (s *InMemoryStorage) GetDeadLetter(id int64) (*internal.DeadLetter, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if id < 1 || id > int64(len(s.deadLetters)) {
+		return nil, nil
+	}
+	letter := s.deadLetters[id-1]
+	return &letter, nil
+}
+
+// ResolveDeadLetter marks a dead letter as reprocessed.
+func (s *InMemoryStorage) ResolveDeadLetter(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id < 1 || id > int64(len(s.deadLetters)) {
+		return fmt.Errorf("dead letter %d not found", id)
+	}
+	now := time.Now()
+	s.deadLetters[id-1].ResolvedAt = &now
+	return nil
+}
+
+// FailDeadLetter records another failed attempt to reprocess a dead letter.
+func (s *InMemoryStorage) FailDeadLetter(id int64, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id < 1 || id > int64(len(s.deadLetters)) {
+		return fmt.Errorf("dead letter %d not found", id)
+	}
+	letter := &s.deadLetters[id-1]
+	letter.Error = reason
+	letter.Attempts++
+	letter.UpdatedAt = time.Now()
+	return nil
+}
+
+// AddOperatorLedgerEntry records a change of the pool operator's commission.
+func (s *InMemoryStorage) AddOperatorLedgerEntry(entry internal.OperatorLedgerEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.addOperatorLedgerEntry(entry)
+	return nil
+}
+
+// addOperatorLedgerEntry appends a ledger entry. The caller holds s.mu.
+func (s *InMemoryStorage) addOperatorLedgerEntry(entry internal.OperatorLedgerEntry) {
+	entry.ID = int64(len(s.operator) + 1)
+	entry.CreatedAt = time.Now()
+	s.operator = append(s.operator, entry)
+}
+
+// AddOperatorWithdrawal records a withdrawal of amount from the pool operator's commission
+// in a region and node type. It fails if amount exceeds the balance.
+func (s *InMemoryStorage) AddOperatorWithdrawal(region string, nodeType string, amount internal.Wei, txHash string, note string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var balance internal.Wei
+	for _, entry := range s.operator {
+		if entry.Region == region && entry.NodeType == nodeType {
+			balance = balance.Add(entry.Amount)
+		}
+	}
+	if balance.Cmp(amount) < 0 {
+		return fmt.Errorf("withdrawal of %s exceeds the operator balance of %s in %s/%s", amount, balance, region, nodeType)
+	}
+	s.addOperatorLedgerEntry(internal.OperatorLedgerEntry{
+		Kind:     internal.OperatorWithdrawal,
+		Region:   region,
+		NodeType: nodeType,
+		Amount:   internal.Wei{}.Sub(amount),
+		TxHash:   txHash,
+		Note:     note,
+	})
+	return nil
+}
+
+// GetOperatorLedger returns the pool operator ledger entries of a kind, or of every kind
+// if kind is empty, newest first.
+func (s *InMemoryStorage) GetOperatorLedger(kind string) ([]internal.OperatorLedgerEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var entries []internal.OperatorLedgerEntry
+	for i := len(s.operator) - 1; i >= 0; i-- {
+		if kind == "" || s.operator[i].Kind == kind {
+			entries = append(entries, s.operator[i])
+		}
+	}
+	return entries, nil
+}
+
+// GetOperatorBalances returns the pool operator's commission per region and node type.
+func (s *InMemoryStorage) GetOperatorBalances() ([]internal.OperatorBalance, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return internal.SumOperatorLedger(s.operator), nil
+}
+
+// ExpireJobCorrelations removes and returns the correlations of a data source that expired
+// at or before now.
+func (s *InMemoryStorage) ExpireJobCorrelations(source string, now time.Time) ([]internal.JobCorrelation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expired []internal.JobCorrelation
+	for key, correlation := range s.correlations {
+		if correlation.Source == source && !correlation.ExpiresAt.After(now) {
+			expired = append(expired, correlation)
+			delete(s.correlations, key)
+			s.failures = append(s.failures, internal.JobFailure{
+				ID:         int64(len(s.failures) + 1),
+				Source:     correlation.Source,
+				RequestID:  correlation.RequestID,
+				EthAddress: correlation.EthAddress,
+				NodeType:   correlation.NodeType,
+				Pipeline:   correlation.Pipeline,
+				ModelID:    correlation.ModelID,
+				ReceivedAt: correlation.ReceivedAt,
+				ExpiredAt:  now,
+			})
+		}
+	}
+	return expired, nil
+}
+
+// GetWorkerMetrics returns the performance metrics of every worker with jobs, failures or
+// sessions between from and to.
+func (s *InMemoryStorage) GetWorkerMetrics(from time.Time, to time.Time) ([]internal.WorkerMetrics, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return internal.ComputeWorkerMetrics(s.jobs, s.failures, s.sessions, from, to), nil
+}
+
+// GetWorkers retrieves all workers stored in-memory.
+func (s *InMemoryStorage) GetWorkers() ([]models.Worker, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	workers := make([]models.Worker, 0, len(s.workers))
+	for _, worker := range s.workers {
+		workers = append(workers, worker)
+	}
+	return workers, nil
+}
+
+// GetFilteredWorkers retrieves the online workers that pass the configured worker filter policy.
+func (s *InMemoryStorage) GetFilteredWorkers() ([]models.Worker, error) {
+	var allowed map[string]bool
+	if s.workerFilter != nil {
+		to := time.Now().UTC()
+		metrics, err := s.GetWorkerMetrics(to.Add(-s.workerFilter.Window()), to)
+		if err != nil {
+			return nil, err
+		}
+		allowed = make(map[string]bool)
+		for _, m := range metrics {
+			allowed[m.EthAddress+"/"+m.NodeType] = s.workerFilter.Allows(m)
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	workers := make([]models.Worker, 0, len(s.workers))
+	for _, worker := range s.workers {
+		if pass, ok := allowed[worker.EthAddress+"/"+worker.NodeType]; worker.IsConnected && (!ok || pass) {
+			workers = append(workers, worker)
+		}
+	}
+	return workers, nil
+}
+
+// UpdateWorkerStatus updates or creates a worker record.
+func (s *InMemoryStorage) UpdateWorkerStatus(id string, connected bool, region string, nodeType string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := workerKey(id, region, nodeType)
+	worker, exists := s.workers[key]
+	if !exists {
+		worker = internal.RemoteWorker{
+			EthAddress: id,
+			NodeType:   nodeType,
+			Region:     region,
+		}
+	}
+	worker.IsConnected = connected
+	s.workers[key] = worker
+	return nil
+}
+
+// workerKey returns the key of a worker in s.workers. Like the sqlite store, a worker is
+// identified by its address, region and node type.
+func workerKey(ethAddress string, region string, nodeType string) string {
+	return ethAddress + "/" + region + "/" + nodeType
+}
+
+// ResetWorkersOnlineStatus sets all workers as disconnected for a given region and nodeType.
+func (s *InMemoryStorage) ResetWorkersOnlineStatus(region, nodeType string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, worker := range s.workers {
+		if worker.Region == region && worker.NodeType == nodeType {
+			worker.IsConnected = false
+			s.workers[key] = worker
+		}
+	}
+	return nil
+}
+
+// AddPendingFees increases the pending fees for a worker.
+func (s *InMemoryStorage) AddPendingFees(ethAddress string, amount int64, region, nodeType string) error {
+	return s.AddPendingFeesWei(ethAddress, internal.NewWei(amount), region, nodeType)
+}
+
+// AddPendingFeesWei increases the pending fees for a worker.
+func (s *InMemoryStorage) AddPendingFeesWei(ethAddress string, amount internal.Wei, region, nodeType string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.addPendingFeesWei(ethAddress, amount, region, nodeType)
+	return nil
+}
+
+// addPendingFeesWei increases the pending fees for a worker, creating it if it does not
+// exist. The caller holds s.mu.
+func (s *InMemoryStorage) addPendingFeesWei(ethAddress string, amount internal.Wei, region, nodeType string) {
+	key := workerKey(ethAddress, region, nodeType)
+	worker, exists := s.workers[key]
+	if !exists {
+		worker = internal.RemoteWorker{
+			EthAddress:  ethAddress,
+			Region:      region,
+			NodeType:    nodeType,
+			PendingFees: amount,
+		}
+	} else {
+		worker.PendingFees = worker.PendingFees.Add(amount)
+	}
+	s.workers[key] = worker
+}
+
+// AddPaidFees records a payout and updates worker balances.
+func (s *InMemoryStorage) AddPaidFees(ethAddress string, amount int64, txHash string, region, nodeType string) error {
+	return s.AddPaidFeesWei(ethAddress, internal.NewWei(amount), txHash, region, nodeType)
+}
+
+// AddPaidFeesWei records a payout and updates worker balances.
+func (s *InMemoryStorage) AddPaidFeesWei(ethAddress string, amount internal.Wei, txHash string, region, nodeType string) error {
+	_, err := s.RecordPayout(internal.PoolPayout{
+		EthAddress: ethAddress,
+		NodeType:   nodeType,
+		Region:     region,
+		TxHash:     txHash,
+		Fees:       amount,
+	})
+	return err
+}
+
+// RecordPayout records a payout, updates worker balances and returns the payout ID. Status
+// defaults to PayoutSubmitted.
+func (s *InMemoryStorage) RecordPayout(payout internal.PoolPayout) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.recordPayout(payout)
+}
+
+// recordPayout records a payout like RecordPayout. The caller holds s.mu.
+func (s *InMemoryStorage) recordPayout(payout internal.PoolPayout) (int64, error) {
+	key := workerKey(payout.EthAddress, payout.Region, payout.NodeType)
+	worker, exists := s.workers[key]
+	if !exists {
+		return 0, fmt.Errorf("failed to find remote worker [%s] to update paid fees", payout.EthAddress)
+	}
+
+	worker.PaidFees = worker.PaidFees.Add(payout.Fees)
+	if worker.PendingFees.Cmp(payout.Fees) >= 0 {
+		worker.PendingFees = worker.PendingFees.Sub(payout.Fees)
+	} else {
+		worker.PendingFees = internal.Wei{}
+	}
+
+	s.workers[key] = worker
+
+	// Store the payout record
+	if payout.Status == "" {
+		payout.Status = internal.PayoutSubmitted
+	}
+	payout.ID = int64(len(s.payouts) + 1)
+	payout.CreatedAt = time.Now()
+	payout.UpdatedAt = payout.CreatedAt
+	s.payouts = append(s.payouts, payout)
+
+	return payout.ID, nil
+}
+
+// RecordPayouts records the payouts of one transaction. The workers are checked first, so
+// either all payouts are recorded or none.
+func (s *InMemoryStorage) RecordPayouts(payouts []internal.PoolPayout) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, payout := range payouts {
+		if _, exists := s.workers[workerKey(payout.EthAddress, payout.Region, payout.NodeType)]; !exists {
+			return nil, fmt.Errorf("failed to find remote worker [%s] to update paid fees", payout.EthAddress)
+		}
+	}
+
+	ids := make([]int64, 0, len(payouts))
+	for _, payout := range payouts {
+		id, err := s.recordPayout(payout)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// ConfirmPayout marks a submitted payout as confirmed in a block.
+func (s *InMemoryStorage) ConfirmPayout(id int64, blockNumber uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	payout, err := s.submittedPayout(id)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	payout.Status = internal.PayoutConfirmed
+	payout.BlockNumber = blockNumber
+	payout.ConfirmedAt = &now
+	payout.UpdatedAt = now
+	return nil
+}
+
+// FailPayout marks a submitted payout as failed and moves its Fees from the worker's paid
+// fees back to its pending fees.
+func (s *InMemoryStorage) FailPayout(id int64, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	payout, err := s.submittedPayout(id)
+	if err != nil {
+		return err
+	}
+	payout.Status = internal.PayoutFailed
+	payout.Error = reason
+	payout.UpdatedAt = time.Now()
+
+	key := workerKey(payout.EthAddress, payout.Region, payout.NodeType)
+	if worker, exists := s.workers[key]; exists {
+		worker.PaidFees = worker.PaidFees.Sub(payout.Fees)
+		worker.PendingFees = worker.PendingFees.Add(payout.Fees)
+		s.workers[key] = worker
+	}
+	return nil
+}
+
+// ReplacePayout marks a submitted payout as replaced and stores its replacement, which has
+// the same amount, as submitted. The worker balances do not change.
+func (s *InMemoryStorage) ReplacePayout(id int64, replacement internal.PoolPayout) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.replacePayout(id, replacement)
+}
+
+// ReplacePayouts replaces the submitted payouts of a transaction like ReplacePayout, all or
+// none. Each replacement gets the Contract of the payout it replaces.
+func (s *InMemoryStorage) ReplacePayouts(txHash string, replacement internal.PoolPayout) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ids []int64
+	for _, payout := range s.payouts {
+		if payout.TxHash == txHash && payout.Status == internal.PayoutSubmitted {
+			ids = append(ids, payout.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return fmt.Errorf("no payouts of transaction %s are submitted", txHash)
+	}
+	for _, id := range ids {
+		replacement.Contract = s.payouts[id-1].Contract
+		if err := s.replacePayout(id, replacement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replacePayout replaces a submitted payout. The caller holds s.mu.
+func (s *InMemoryStorage) replacePayout(id int64, replacement internal.PoolPayout) error {
+	payout, err := s.submittedPayout(id)
+	if err != nil {
+		return err
+	}
+	payout.Status = internal.PayoutReplaced
+	payout.UpdatedAt = time.Now()
+
+	replacement.ID = int64(len(s.payouts) + 1)
+	replacement.EthAddress = payout.EthAddress
+	replacement.NodeType = payout.NodeType
+	replacement.Region = payout.Region
+	replacement.Fees = payout.Fees
+	replacement.Status = internal.PayoutSubmitted
+	replacement.ReplacesID = &id
+	replacement.CreatedAt = time.Now()
+	replacement.UpdatedAt = replacement.CreatedAt
+	s.payouts = append(s.payouts, replacement)
+	return nil
+}
+
+// ReviveReplacedPayout marks a replaced payout whose transaction was mined after all as
+// submitted again, and the submitted payout that replaced it as replaced.
+func (s *InMemoryStorage) ReviveReplacedPayout(replacedID int64, replacementID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if replacedID < 1 || replacedID > int64(len(s.payouts)) || s.payouts[replacedID-1].Status != internal.PayoutReplaced {
+		return fmt.Errorf("payout %d is not replaced", replacedID)
+	}
+	replacement, err := s.submittedPayout(replacementID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	replacement.Status = internal.PayoutReplaced
+	replacement.UpdatedAt = now
+	s.payouts[replacedID-1].Status = internal.PayoutSubmitted
+	s.payouts[replacedID-1].UpdatedAt = now
+	return nil
+}
+
+// SetPayoutsCancel records the transaction sent to cancel the submitted payouts of a
+// transaction.
+func (s *InMemoryStorage) SetPayoutsCancel(txHash string, cancelTxHash string, gasFeeCap internal.Wei, gasTipCap internal.Wei) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cancelled := 0
+	for i := range s.payouts {
+		payout := &s.payouts[i]
+		if payout.TxHash != txHash || payout.Status != internal.PayoutSubmitted {
+			continue
+		}
+		now := time.Now()
+		payout.CancelTxHash = cancelTxHash
+		payout.CancelledAt = &now
+		payout.GasFeeCap = gasFeeCap
+		payout.GasTipCap = gasTipCap
+		payout.Error = ""
+		payout.UpdatedAt = now
+		cancelled++
+	}
+	if cancelled == 0 {
+		return fmt.Errorf("no payouts of transaction %s are submitted", txHash)
+	}
+	return nil
+}
+
+// SetPayoutsError records why the stuck transaction of the submitted payouts of a
+// transaction is not replaced anymore. The payouts stay submitted.
+func (s *InMemoryStorage) SetPayoutsError(txHash string, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updated := 0
+	for i := range s.payouts {
+		payout := &s.payouts[i]
+		if payout.TxHash != txHash || payout.Status != internal.PayoutSubmitted {
+			continue
+		}
+		payout.Error = reason
+		payout.UpdatedAt = time.Now()
+		updated++
+	}
+	if updated == 0 {
+		return fmt.Errorf("no payouts of transaction %s are submitted", txHash)
+	}
+	return nil
+}
+
+// submittedPayout returns a submitted payout. The caller holds s.mu.
+func (s *InMemoryStorage) submittedPayout(id int64) (*internal.PoolPayout, error) {
+	if id < 1 || id > int64(len(s.payouts)) {
+		return nil, fmt.Errorf("payout %d not found", id)
+	}
+	payout := &s.payouts[id-1]
+	if payout.Status != internal.PayoutSubmitted {
+		return nil, fmt.Errorf("payout %d is %s, not submitted", id, payout.Status)
+	}
+	return payout, nil
+}
+
+func (s *InMemoryStorage) GetPendingFees() (float64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var totalPendingFees internal.Wei
+	for _, worker := range s.workers {
+		totalPendingFees = totalPendingFees.Add(worker.PendingFees)
+	}
+
+	// Convert wei to ether
+	return totalPendingFees.Eth(), nil
+}
+
+func (s *InMemoryStorage) GetPaidFees() (float64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var totalPaidFees internal.Wei
+	for _, worker := range s.workers {
+		totalPaidFees = totalPaidFees.Add(worker.PaidFees)
+	}
+
+	// Convert wei to ether
+	return totalPaidFees.Eth(), nil
+}
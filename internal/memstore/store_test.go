@@ -0,0 +1,105 @@
+package memstore
+
+import (
+	"errors"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	"testing"
+)
+
+// newTestStore returns an empty in-memory store.
+func newTestStore() *InMemoryStorage {
+	s := &InMemoryStorage{}
+	s.Init(nil)
+	return s
+}
+
+// credit returns an ApplyEvent callback crediting wei to a worker and storing the cursor
+// of the event, which then fails with fail if it is not nil.
+func credit(event *internal.EventLog, wei int64, fail error) func(tx internal.Store) error {
+	return func(tx internal.Store) error {
+		if err := tx.AddPendingFeesWei("0xworker", internal.NewWei(wei), "test", "transcode"); err != nil {
+			return err
+		}
+		if err := tx.SetCursor(internal.DataSourceCursor{Source: event.Source, LastEventID: event.EventID}); err != nil {
+			return err
+		}
+		return fail
+	}
+}
+
+func TestApplyEvent(t *testing.T) {
+	type apply struct {
+		source  string
+		eventID int64
+		err     error
+	}
+	tests := []struct {
+		name        string
+		applies     []apply
+		wantApplied []bool
+		wantPending int64
+		wantEvents  int
+		wantCursor  int64
+	}{
+		{
+			name:        "new events are applied",
+			applies:     []apply{{"a", 1, nil}, {"a", 2, nil}},
+			wantApplied: []bool{true, true},
+			wantPending: 200,
+			wantEvents:  2,
+			wantCursor:  2,
+		},
+		{
+			name:        "duplicate events are not applied again",
+			applies:     []apply{{"a", 1, nil}, {"a", 1, nil}},
+			wantApplied: []bool{true, false},
+			wantPending: 100,
+			wantEvents:  1,
+			wantCursor:  1,
+		},
+		{
+			name:        "the same event ID of another source is applied",
+			applies:     []apply{{"a", 1, nil}, {"b", 1, nil}},
+			wantApplied: []bool{true, true},
+			wantPending: 200,
+			wantEvents:  2,
+			wantCursor:  1,
+		},
+		{
+			name:        "failed events leave nothing behind and can be applied again",
+			applies:     []apply{{"a", 1, nil}, {"a", 2, errors.New("failed")}, {"a", 2, nil}},
+			wantApplied: []bool{true, false, true},
+			wantPending: 200,
+			wantEvents:  2,
+			wantCursor:  2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore()
+			for i, a := range tt.applies {
+				event := &internal.EventLog{Source: a.source, EventID: a.eventID, Type: "job-processed"}
+				applied, err := s.ApplyEvent(event, credit(event, 100, a.err))
+				if (err != nil) != (a.err != nil) {
+					t.Fatalf("apply %d returned %v, want %v", i, err, a.err)
+				}
+				if applied != tt.wantApplied[i] {
+					t.Errorf("apply %d applied = %v, want %v", i, applied, tt.wantApplied[i])
+				}
+			}
+
+			workers, _ := s.GetRemoteWorkers()
+			if len(workers) != 1 || workers[0].PendingFees.Cmp(internal.NewWei(tt.wantPending)) != 0 {
+				t.Errorf("workers = %+v, want pending fees of %d", workers, tt.wantPending)
+			}
+			events, _ := s.GetEventLog(0, 100)
+			if len(events) != tt.wantEvents {
+				t.Errorf("event log holds %d events, want %d", len(events), tt.wantEvents)
+			}
+			cursor, _ := s.GetCursor("a")
+			if cursor == nil || cursor.LastEventID != tt.wantCursor {
+				t.Errorf("cursor = %+v, want last event ID %d", cursor, tt.wantCursor)
+			}
+		})
+	}
+}
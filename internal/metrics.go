@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"sort"
+	"time"
+)
+
+// WorkerMetrics are the performance metrics of a worker over a time window. Response times
+// are in the unit of the job-processed responseTime.
+type WorkerMetrics struct {
+	EthAddress    string `json:"ethAddress"`
+	NodeType      string `json:"nodeType"`
+	JobsProcessed int    `json:"jobsProcessed"`
+	// JobsFailed counts the jobs the worker received but never reported as processed.
+	JobsFailed      int     `json:"jobsFailed"`
+	FailureRate     float64 `json:"failureRate"`
+	ResponseTimeP50 int64   `json:"responseTimeP50"`
+	ResponseTimeP95 int64   `json:"responseTimeP95"`
+	ResponseTimeP99 int64   `json:"responseTimeP99"`
+	// Uptime is the share of the window the worker was connected to at least one orchestrator.
+	Uptime float64 `json:"uptime"`
+	// Sessions counts the worker's sessions in the window. Workers connected before sessions
+	// were recorded have none until they reconnect, their uptime is unknown.
+	Sessions int       `json:"sessions"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+}
+
+// workerMetricsKey identifies the metrics of a worker.
+type workerMetricsKey struct {
+	ethAddress string
+	nodeType   string
+}
+
+// ComputeWorkerMetrics computes the metrics of every worker from its processed jobs, job
+// failures and sessions between from and to. Rows outside the window are ignored, sessions
+// are clipped to it.
+func ComputeWorkerMetrics(jobs []ProcessedJob, failures []JobFailure, sessions []WorkerSession, from time.Time, to time.Time) []WorkerMetrics {
+	metrics := make(map[workerMetricsKey]*WorkerMetrics)
+	responseTimes := make(map[workerMetricsKey][]int64)
+	uptimes := make(map[workerMetricsKey][][2]time.Time)
+	get := func(ethAddress string, nodeType string) (workerMetricsKey, *WorkerMetrics) {
+		key := workerMetricsKey{ethAddress, nodeType}
+		m, ok := metrics[key]
+		if !ok {
+			m = &WorkerMetrics{EthAddress: ethAddress, NodeType: nodeType, From: from, To: to}
+			metrics[key] = m
+		}
+		return key, m
+	}
+
+	for _, job := range jobs {
+		if job.EthAddress == "" || job.ProcessedAt.Before(from) || !job.ProcessedAt.Before(to) {
+			continue
+		}
+		key, m := get(job.EthAddress, job.NodeType)
+		m.JobsProcessed++
+		responseTimes[key] = append(responseTimes[key], job.ResponseTime)
+	}
+	for _, failure := range failures {
+		if failure.EthAddress == "" || failure.ReceivedAt.Before(from) || !failure.ReceivedAt.Before(to) {
+			continue
+		}
+		_, m := get(failure.EthAddress, failure.NodeType)
+		m.JobsFailed++
+	}
+	for _, session := range sessions {
+		start, end := session.ConnectedAt, to
+		if session.DisconnectedAt != nil && session.DisconnectedAt.Before(end) {
+			end = *session.DisconnectedAt
+		}
+		if start.Before(from) {
+			start = from
+		}
+		if !start.Before(end) {
+			continue
+		}
+		key, m := get(session.EthAddress, session.NodeType)
+		m.Sessions++
+		uptimes[key] = append(uptimes[key], [2]time.Time{start, end})
+	}
+
+	window := to.Sub(from)
+	result := make([]WorkerMetrics, 0, len(metrics))
+	for key, m := range metrics {
+		if total := m.JobsProcessed + m.JobsFailed; total > 0 {
+			m.FailureRate = float64(m.JobsFailed) / float64(total)
+		}
+		if times := responseTimes[key]; len(times) > 0 {
+			sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+			m.ResponseTimeP50 = percentile(times, 50)
+			m.ResponseTimeP95 = percentile(times, 95)
+			m.ResponseTimeP99 = percentile(times, 99)
+		}
+		if window > 0 {
+			m.Uptime = float64(coveredDuration(uptimes[key])) / float64(window)
+		}
+		result = append(result, *m)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].EthAddress != result[j].EthAddress {
+			return result[i].EthAddress < result[j].EthAddress
+		}
+		return result[i].NodeType < result[j].NodeType
+	})
+	return result
+}
+
+// percentile returns the nearest-rank percentile p of sorted values.
+func percentile(sorted []int64, p int) int64 {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// coveredDuration returns the duration covered by the union of the intervals. Sessions on
+// several orchestrators at once count once.
+func coveredDuration(intervals [][2]time.Time) time.Duration {
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i][0].Before(intervals[j][0]) })
+	var covered time.Duration
+	var end time.Time
+	for _, interval := range intervals {
+		if interval[0].After(end) {
+			covered += interval[1].Sub(interval[0])
+			end = interval[1]
+		} else if interval[1].After(end) {
+			covered += interval[1].Sub(end)
+			end = interval[1]
+		}
+	}
+	return covered
+}
@@ -2,20 +2,249 @@ package internal
 
 import "time"
 
+// EventLog represents a stored orchestrator event. Source and EventID identify the event at
+// the orchestrator it was ingested from and are unique together; rows stored before they
+// were recorded have an empty Source.
 type EventLog struct {
 	ID        int64  `gorm:"primaryKey;autoIncrement" json:"id"`
+	Source    string `json:"source" gorm:"uniqueIndex:idx_event_log_source_event,where:source <> ''"`
+	EventID   int64  `json:"eventID" gorm:"uniqueIndex:idx_event_log_source_event,where:source <> ''"`
+	NodeType  string `json:"nodeType"`
+	Version   int    `json:"version"`
 	Type      string `json:"type"`
 	Data      string `json:"data"`
 	CreatedAt int64  `json:"created_at" gorm:"autoUpdateTime"`
 }
 
+// DataSourceCursor records the last orchestrator event ID ingested from a data source.
+type DataSourceCursor struct {
+	Source      string    `gorm:"primaryKey" json:"source"`
+	LastEventID int64     `json:"lastEventID"`
+	LastEventAt int64     `json:"lastEventAt"`
+	UpdatedAt   time.Time `json:"updatedAt" gorm:"autoUpdateTime"`
+}
+
+// Data source health states.
+const (
+	DataSourceHealthy     = "healthy"
+	DataSourceFailing     = "failing"
+	DataSourceCircuitOpen = "circuit-open"
+)
+
+// DataSourceHealth is the fetch health of a data source as last seen by the data loader.
+type DataSourceHealth struct {
+	Source              string     `gorm:"primaryKey" json:"source"`
+	NodeType            string     `json:"nodeType"`
+	State               string     `json:"state"`
+	LastAttemptAt       *time.Time `json:"lastAttemptAt,omitempty"`
+	LastSuccessAt       *time.Time `json:"lastSuccessAt,omitempty"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	LastError           string     `json:"lastError,omitempty"`
+	CircuitOpenUntil    *time.Time `json:"circuitOpenUntil,omitempty"`
+}
+
+// JobCorrelation links an AI job-received event to the worker that received the job until
+// the matching job-processed event arrives or the correlation expires.
+type JobCorrelation struct {
+	Source     string    `gorm:"primaryKey" json:"source"`
+	RequestID  string    `gorm:"primaryKey" json:"requestID"`
+	EthAddress string    `json:"ethAddress"`
+	NodeType   string    `json:"nodeType"`
+	Pipeline   string    `json:"pipeline"`
+	ModelID    string    `json:"modelID"`
+	ReceivedAt time.Time `json:"receivedAt"`
+	ExpiresAt  time.Time `json:"expiresAt" gorm:"index"`
+}
+
+// JobFailure records an AI job a worker received but never reported as processed before
+// its job correlation expired.
+type JobFailure struct {
+	ID         int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	Source     string    `json:"source"`
+	RequestID  string    `json:"requestID"`
+	EthAddress string    `gorm:"index" json:"ethAddress"`
+	NodeType   string    `json:"nodeType"`
+	Pipeline   string    `json:"pipeline,omitempty"`
+	ModelID    string    `json:"modelID,omitempty"`
+	ReceivedAt time.Time `gorm:"index" json:"receivedAt"`
+	ExpiredAt  time.Time `json:"expiredAt"`
+}
+
+// WorkerSession records a worker's connection to the orchestrator of a data source. A worker
+// is connected while it has a session without DisconnectedAt on any data source.
+type WorkerSession struct {
+	ID             int64      `gorm:"primaryKey;autoIncrement" json:"id"`
+	Source         string     `gorm:"index" json:"source"`
+	EthAddress     string     `gorm:"index" json:"ethAddress"`
+	NodeType       string     `json:"nodeType"`
+	Region         string     `json:"region"`
+	Connection     string     `json:"connection,omitempty"`
+	ConnectedAt    time.Time  `json:"connectedAt"`
+	DisconnectedAt *time.Time `json:"disconnectedAt,omitempty"`
+}
+
+// PoolOperator is the ReassignedTo value of unattributed fees that were given to the pool operator.
+const PoolOperator = "pool-operator"
+
+// UnattributedFee holds the worker share of a processed job that could not be attributed to
+// a worker, until an admin reassigns it to a worker or to the pool operator.
+type UnattributedFee struct {
+	ID           int64      `gorm:"primaryKey;autoIncrement" json:"id"`
+	Source       string     `json:"source"`
+	EventID      int64      `json:"eventID"`
+	RequestID    string     `json:"requestID"`
+	NodeType     string     `json:"nodeType"`
+	Region       string     `json:"region"`
+	Fees         Wei        `json:"fees"`
+	ReassignedTo string     `json:"reassignedTo,omitempty"`
+	ReassignedAt *time.Time `json:"reassignedAt,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt" gorm:"autoCreateTime"`
+}
+
+// ProcessedJob records a processed job with the fees it earned, so earnings can be audited
+// per job. EthAddress is empty for jobs whose fees are unattributed.
+type ProcessedJob struct {
+	ID                  int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	Source              string    `json:"source"`
+	EventID             int64     `json:"eventID"`
+	RequestID           string    `json:"requestID"`
+	EthAddress          string    `gorm:"index" json:"ethAddress"`
+	NodeType            string    `json:"nodeType"`
+	Region              string    `json:"region"`
+	Pipeline            string    `gorm:"index" json:"pipeline,omitempty"`
+	ModelID             string    `json:"modelID,omitempty"`
+	ComputeUnits        int       `json:"computeUnits"`
+	PricePerComputeUnit int       `json:"pricePerComputeUnit"`
+	ResponseTime        int64     `json:"responseTime"`
+	Fees                Wei       `json:"fees"`
+	WorkerFees          Wei       `json:"workerFees"`
+	ProcessedAt         time.Time `gorm:"index" json:"processedAt"`
+}
+
+// JobFilter selects processed jobs. Empty fields match every job.
+type JobFilter struct {
+	EthAddress string
+	NodeType   string
+	Pipeline   string
+	ModelID    string
+	// From and To bound ProcessedAt, From inclusive and To exclusive.
+	From   *time.Time
+	To     *time.Time
+	Limit  int
+	Offset int
+}
+
+// DeadLetter holds an event envelope that could not be parsed or applied, until it is
+// reprocessed successfully. Its event was not recorded in the event log.
+type DeadLetter struct {
+	ID         int64      `gorm:"primaryKey;autoIncrement" json:"id"`
+	Source     string     `gorm:"uniqueIndex:idx_dead_letter_source_event" json:"source"`
+	EventID    int64      `gorm:"uniqueIndex:idx_dead_letter_source_event" json:"eventID"`
+	NodeType   string     `json:"nodeType"`
+	Envelope   string     `json:"envelope"`
+	Error      string     `json:"error"`
+	Attempts   int        `json:"attempts"`
+	CreatedAt  time.Time  `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt  time.Time  `json:"updatedAt" gorm:"autoUpdateTime"`
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+}
+
+// Kinds of pool operator ledger entries.
+const (
+	// OperatorCommission is the pool's share of a processed job.
+	OperatorCommission = "commission"
+	// OperatorReassigned is unattributed fees an admin gave to the pool operator.
+	OperatorReassigned = "reassigned"
+	// OperatorWithdrawal is commission the pool operator took out of the pool wallet.
+	OperatorWithdrawal = "withdrawal"
+)
+
+// OperatorLedgerEntry records a change of the pool operator's commission in a region and
+// node type. Withdrawals have a negative Amount.
+type OperatorLedgerEntry struct {
+	ID        int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	Kind      string    `gorm:"index" json:"kind"`
+	Region    string    `gorm:"index:idx_operator_ledger_account" json:"region"`
+	NodeType  string    `gorm:"index:idx_operator_ledger_account" json:"nodeType"`
+	Amount    Wei       `json:"amount"`
+	Source    string    `json:"source,omitempty"`
+	EventID   int64     `json:"eventID,omitempty"`
+	RequestID string    `json:"requestID,omitempty"`
+	TxHash    string    `json:"txHash,omitempty"`
+	Note      string    `json:"note,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// OperatorBalance is the pool operator's commission in a region and node type.
+type OperatorBalance struct {
+	Region   string `json:"region"`
+	NodeType string `json:"nodeType"`
+	// Accrued is the commission and reassigned fees, Withdrawn the withdrawals.
+	Accrued   Wei `json:"accrued"`
+	Withdrawn Wei `json:"withdrawn"`
+	Balance   Wei `json:"balance"`
+}
+
+// CommissionPeriod is the commission in effect for events from EffectiveFrom until the
+// EffectiveFrom of the next period. Events before the first period use the first period.
+type CommissionPeriod struct {
+	ID            int64     `gorm:"primaryKey;autoIncrement" json:"id"`
+	EffectiveFrom time.Time `gorm:"uniqueIndex" json:"effectiveFrom"`
+	// Rate is the PoolCommissionRate of the period.
+	Rate      float64          `json:"rate"`
+	Schedule  CommissionConfig `gorm:"serializer:json" json:"schedule"`
+	CreatedAt time.Time        `json:"createdAt"`
+}
+
+// Payout statuses. A payout is submitted until its transaction has ConfirmationDepth
+// confirmations, or failed if it reverted or was dropped; a failed payout's amount is back
+// in the worker's pending fees. A replaced payout was superseded by another transaction
+// with the same nonce, which has a payout of its own.
+const (
+	PayoutSubmitted = "submitted"
+	PayoutConfirmed = "confirmed"
+	PayoutFailed    = "failed"
+	PayoutReplaced  = "replaced"
+)
+
 // PoolPayout represents the pool payout record.
 type PoolPayout struct {
-	ID         int64     `gorm:"primaryKey;autoIncrement" json:"id"`
-	EthAddress string    `json:"ethAddress"`
-	TxHash     string    `json:"txHash"`
-	Fees       int64     `json:"fees"`
-	CreatedAt  time.Time `json:"createdAt" gorm:"autoUpdateTime"`
+	ID         int64  `gorm:"primaryKey;autoIncrement" json:"id"`
+	EthAddress string `json:"ethAddress"`
+	NodeType   string `json:"nodeType"`
+	Region     string `json:"region"`
+	TxHash     string `json:"txHash"`
+	Fees       Wei    `json:"fees"`
+	Status     string `gorm:"index" json:"status"`
+	// Nonce is the nonce of the transaction, if it is known.
+	Nonce *uint64 `json:"nonce,omitempty"`
+	// GasFeeCap and GasTipCap are the max fee and priority fee per gas the transaction was
+	// sent with, which a replacement has to outbid.
+	GasFeeCap Wei `json:"gasFeeCap"`
+	GasTipCap Wei `json:"gasTipCap"`
+	// ReplacesID is the payout this one replaced with a fee-bumped transaction.
+	ReplacesID *int64 `json:"replacesID,omitempty"`
+	// Contract is the disperse contract a batched payout was sent through. The payouts of
+	// one batch share their transaction.
+	Contract string `json:"contract,omitempty"`
+	// CancelTxHash is the transaction sent to cancel the payout by using up its nonce, and
+	// CancelledAt when it was sent.
+	CancelTxHash string     `json:"cancelTxHash,omitempty"`
+	CancelledAt  *time.Time `json:"cancelledAt,omitempty"`
+	// BlockNumber is the block the transaction was confirmed in.
+	BlockNumber uint64 `json:"blockNumber,omitempty"`
+	// Error is why the payout failed, or why the stuck transaction of a submitted payout is
+	// not replaced anymore.
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt" gorm:"autoCreateTime"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	ConfirmedAt *time.Time `json:"confirmedAt,omitempty"`
+}
+
+// CountsAsPaid reports whether the payout is part of the worker's paid fees, which it is
+// unless it failed or was replaced.
+func (pp PoolPayout) CountsAsPaid() bool {
+	return pp.Status != PayoutFailed && pp.Status != PayoutReplaced
 }
 
 func (pp PoolPayout) GetID() string {
@@ -25,7 +254,7 @@ func (pp PoolPayout) GetTimestamp() int64 {
 	return pp.CreatedAt.Unix()
 }
 func (pp PoolPayout) GetAmount() int64 {
-	return pp.Fees
+	return pp.Fees.Int64()
 }
 
 // RemoteWorker represents a worker. The unique composite key is built from EthAddress, NodeType, and Region.
@@ -34,8 +263,8 @@ type RemoteWorker struct {
 	NodeType    string    `json:"nodeType" gorm:"primaryKey;not null"`
 	Region      string    `json:"region" gorm:"primaryKey;not null"`
 	IsConnected bool      `json:"is_connected"`
-	PendingFees int64     `json:"pending_fees"`
-	PaidFees    int64     `json:"paid_fees"`
+	PendingFees Wei       `json:"pending_fees"`
+	PaidFees    Wei       `json:"paid_fees"`
 	LastUpdated time.Time `json:"last_updated" gorm:"autoUpdateTime"`
 	Connection  string    `json:"connection,omitempty"`
 }
@@ -44,10 +273,10 @@ func (rw RemoteWorker) GetID() string {
 	return rw.EthAddress
 }
 func (rw RemoteWorker) GetPaidFees() int64 {
-	return rw.PaidFees
+	return rw.PaidFees.Int64()
 }
 func (rw RemoteWorker) GetPendingFees() int64 {
-	return rw.PendingFees
+	return rw.PendingFees.Int64()
 }
 func (rw RemoteWorker) GetNodeType() string {
 	return rw.NodeType
@@ -0,0 +1,130 @@
+package internal
+
+import (
+	pool "github.com/Livepeer-Open-Pool/openpool-plugin"
+	"time"
+)
+
+// Store extends the shared pool.StorageInterface with the operations the plugins of this
+// manager rely on. Storage plugins implement it and the other plugins type assert to it.
+type Store interface {
+	pool.StorageInterface
+	// AddPendingFeesWei credits wei to a worker's pending fees. The shared AddPendingFees
+	// carries wei as int64, which overflows at about 9.2 ETH.
+	AddPendingFeesWei(ethAddress string, amount Wei, region string, nodeType string) error
+	// AddPaidFeesWei moves wei from a worker's pending to its paid fees and records the payout.
+	AddPaidFeesWei(ethAddress string, amount Wei, txHash string, region string, nodeType string) error
+	// RecordPayout moves the payout's Fees from the worker's pending to its paid fees and
+	// stores the payout, returning its ID. Status defaults to PayoutSubmitted.
+	RecordPayout(payout PoolPayout) (int64, error)
+	// RecordPayouts records the payouts of one transaction like RecordPayout, all or none,
+	// and returns their IDs.
+	RecordPayouts(payouts []PoolPayout) ([]int64, error)
+	// ConfirmPayout marks a submitted payout as confirmed in a block.
+	ConfirmPayout(id int64, blockNumber uint64) error
+	// ReplacePayout marks a submitted payout as replaced and stores its replacement, which
+	// has the same amount, as submitted. The worker balances do not change.
+	ReplacePayout(id int64, replacement PoolPayout) error
+	// ReplacePayouts replaces the submitted payouts of a transaction like ReplacePayout, all
+	// or none. Each replacement gets the Contract of the payout it replaces.
+	ReplacePayouts(txHash string, replacement PoolPayout) error
+	// ReviveReplacedPayout marks a replaced payout whose transaction was mined after all as
+	// submitted again, and the submitted payout that replaced it as replaced.
+	ReviveReplacedPayout(replacedID int64, replacementID int64) error
+	// SetPayoutsCancel records the transaction sent to cancel the submitted payouts of a
+	// transaction.
+	SetPayoutsCancel(txHash string, cancelTxHash string, gasFeeCap Wei, gasTipCap Wei) error
+	// SetPayoutsError records why the stuck transaction of the submitted payouts of a
+	// transaction is not replaced anymore. The payouts stay submitted.
+	SetPayoutsError(txHash string, reason string) error
+	// FailPayout marks a submitted payout as failed and moves its Fees from the worker's
+	// paid fees back to its pending fees.
+	FailPayout(id int64, reason string) error
+	// NewScratchStore returns an empty store of the same kind, e.g. to replay events into.
+	NewScratchStore() (Store, error)
+	// GetEventLog returns up to limit stored events with an ID above afterID, in ID order.
+	GetEventLog(afterID int64, limit int) ([]EventLog, error)
+	// GetRemoteWorkers returns all workers with their balances and connection state.
+	GetRemoteWorkers() ([]RemoteWorker, error)
+	// SetWorkerBalances overwrites the balances and connection state of the given workers,
+	// creating the ones that do not exist.
+	SetWorkerBalances(workers []RemoteWorker) error
+	// GetPayouts returns the payouts with a status, or all payouts if status is empty, in
+	// the order they were recorded.
+	GetPayouts(status string) ([]PoolPayout, error)
+	// AddProcessedJob records a processed job.
+	AddProcessedJob(job ProcessedJob) error
+	// GetProcessedJobs returns the processed jobs matching filter, newest first.
+	GetProcessedJobs(filter JobFilter) ([]ProcessedJob, error)
+	// AddCommissionPeriod stores a commission period, replacing the one with the same
+	// EffectiveFrom.
+	AddCommissionPeriod(period CommissionPeriod) error
+	// GetCommissionHistory returns the commission periods sorted by EffectiveFrom.
+	GetCommissionHistory() ([]CommissionPeriod, error)
+	// GetWorkerVolume returns the fees of the jobs a worker processed from (inclusive) to
+	// (exclusive).
+	GetWorkerVolume(ethAddress string, from time.Time, to time.Time) (Wei, error)
+	// AddDeadLetter records an event that could not be parsed or applied. Recording the same
+	// source event again replaces its envelope and error and counts another attempt.
+	AddDeadLetter(letter DeadLetter) error
+	// GetDeadLetters returns the dead letters, including the resolved ones if all is set.
+	GetDeadLetters(all bool) ([]DeadLetter, error)
+	// GetDeadLetter returns a dead letter, or nil if it does not exist.
+	GetDeadLetter(id int64) (*DeadLetter, error)
+	// ResolveDeadLetter marks a dead letter as reprocessed.
+	ResolveDeadLetter(id int64) error
+	// FailDeadLetter records another failed attempt to reprocess a dead letter.
+	FailDeadLetter(id int64, reason string) error
+	// GetCursor returns the ingestion cursor of a data source, or nil if none was stored yet.
+	GetCursor(source string) (*DataSourceCursor, error)
+	// ApplyEvent stores an event ingested from a data source and calls apply with a Store
+	// bound to the same transaction, so the event log and the state it changes are written
+	// together or not at all. It returns false without calling apply if the event was
+	// already stored for that source.
+	ApplyEvent(event *EventLog, apply func(tx Store) error) (bool, error)
+	// Transaction calls fn with a Store bound to one transaction, which is committed if fn
+	// succeeds. The other reads and writes of the store, e.g. payouts and reassignments,
+	// wait until it ends.
+	Transaction(fn func(tx Store) error) error
+	// SetCursor stores the ingestion cursor of a data source.
+	SetCursor(cursor DataSourceCursor) error
+	// GetDataSourceHealth returns the stored health of all data sources.
+	GetDataSourceHealth() ([]DataSourceHealth, error)
+	// SetDataSourceHealth stores the health of a data source.
+	SetDataSourceHealth(health DataSourceHealth) error
+	// OpenWorkerSession marks a worker as connected to the orchestrator of a data source,
+	// ending the session it may still have there.
+	OpenWorkerSession(session WorkerSession) error
+	// CloseWorkerSession ends a worker's session on a data source. The worker stays connected
+	// while it has sessions on other data sources.
+	CloseWorkerSession(source string, ethAddress string, region string, nodeType string, at time.Time) error
+	// CloseSourceSessions ends all sessions on a data source, e.g. when its orchestrator resets.
+	CloseSourceSessions(source string, region string, nodeType string, at time.Time) error
+	// AddJobCorrelation stores the worker a job was received by.
+	AddJobCorrelation(correlation JobCorrelation) error
+	// TakeJobCorrelation removes and returns the correlation of a job, or nil if there is none.
+	TakeJobCorrelation(source string, requestID string) (*JobCorrelation, error)
+	// AddUnattributedFees records fees that could not be attributed to a worker.
+	AddUnattributedFees(fee UnattributedFee) error
+	// GetUnattributedFees returns the unattributed fees, including the reassigned ones if all is set.
+	GetUnattributedFees(all bool) ([]UnattributedFee, error)
+	// ReassignUnattributedFees credits unattributed fees to a worker's pending fees, or to the
+	// pool operator if ethAddress is PoolOperator.
+	ReassignUnattributedFees(id int64, ethAddress string) error
+	// AddOperatorLedgerEntry records a change of the pool operator's commission.
+	AddOperatorLedgerEntry(entry OperatorLedgerEntry) error
+	// AddOperatorWithdrawal records a withdrawal of amount from the pool operator's commission
+	// in a region and node type. It fails if amount exceeds the balance.
+	AddOperatorWithdrawal(region string, nodeType string, amount Wei, txHash string, note string) error
+	// GetOperatorLedger returns the pool operator ledger entries of a kind, or of every kind
+	// if kind is empty, newest first.
+	GetOperatorLedger(kind string) ([]OperatorLedgerEntry, error)
+	// GetOperatorBalances returns the pool operator's commission per region and node type.
+	GetOperatorBalances() ([]OperatorBalance, error)
+	// ExpireJobCorrelations removes and returns the correlations of a data source that expired
+	// at or before now, in event time, recording each one as a JobFailure of its worker.
+	ExpireJobCorrelations(source string, now time.Time) ([]JobCorrelation, error)
+	// GetWorkerMetrics returns the performance metrics of every worker with jobs, failures
+	// or sessions between from and to.
+	GetWorkerMetrics(from time.Time, to time.Time) ([]WorkerMetrics, error)
+}
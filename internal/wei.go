@@ -0,0 +1,168 @@
+package internal
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// BasisPoints is the number of basis points in a whole.
+const BasisPoints = 10000
+
+// RateBasisPoints converts a rate such as PoolCommissionRate to basis points, rounded to
+// the nearest one.
+func RateBasisPoints(rate float64) int64 {
+	return int64(math.Round(rate * BasisPoints))
+}
+
+// weiPerEth converts wei to ETH.
+var weiPerEth = new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil))
+
+// Wei is an arbitrary-precision amount of wei. It is immutable, the zero value is 0. It is
+// stored as decimal text and encoded in JSON as a number literal, which decoders that read
+// numbers as float64 can only represent approximately.
+type Wei struct {
+	i *big.Int
+}
+
+// NewWei returns an amount of wei.
+func NewWei(amount int64) Wei {
+	return Wei{big.NewInt(amount)}
+}
+
+// WeiFromBig returns an amount of wei. The value is copied.
+func WeiFromBig(amount *big.Int) Wei {
+	if amount == nil {
+		return Wei{}
+	}
+	return Wei{new(big.Int).Set(amount)}
+}
+
+// ParseWei parses a decimal amount of wei.
+func ParseWei(s string) (Wei, error) {
+	i, ok := new(big.Int).SetString(strings.TrimSpace(s), 10)
+	if !ok {
+		return Wei{}, fmt.Errorf("invalid wei amount %q", s)
+	}
+	return Wei{i}, nil
+}
+
+// Big returns the amount as a new big.Int.
+func (w Wei) Big() *big.Int {
+	if w.i == nil {
+		return new(big.Int)
+	}
+	return new(big.Int).Set(w.i)
+}
+
+// Add returns w + o.
+func (w Wei) Add(o Wei) Wei {
+	return Wei{new(big.Int).Add(w.Big(), o.Big())}
+}
+
+// Sub returns w - o.
+func (w Wei) Sub(o Wei) Wei {
+	return Wei{new(big.Int).Sub(w.Big(), o.Big())}
+}
+
+// MulBasisPoints returns w * bps / BasisPoints, truncated towards zero.
+func (w Wei) MulBasisPoints(bps int64) Wei {
+	product := new(big.Int).Mul(w.Big(), big.NewInt(bps))
+	return Wei{product.Quo(product, big.NewInt(BasisPoints))}
+}
+
+// Cmp compares w and o and returns -1, 0 or +1.
+func (w Wei) Cmp(o Wei) int {
+	return w.Big().Cmp(o.Big())
+}
+
+// Sign returns -1, 0 or +1 depending on the sign of w.
+func (w Wei) Sign() int {
+	if w.i == nil {
+		return 0
+	}
+	return w.i.Sign()
+}
+
+// Int64 returns the amount clamped to the int64 range, for interfaces that carry wei as int64.
+func (w Wei) Int64() int64 {
+	i := w.Big()
+	switch {
+	case i.IsInt64():
+		return i.Int64()
+	case i.Sign() > 0:
+		return math.MaxInt64
+	default:
+		return math.MinInt64
+	}
+}
+
+// Eth returns the amount in ETH.
+func (w Wei) Eth() float64 {
+	eth, _ := new(big.Float).Quo(new(big.Float).SetInt(w.Big()), weiPerEth).Float64()
+	return eth
+}
+
+// String returns the decimal amount of wei.
+func (w Wei) String() string {
+	return w.Big().String()
+}
+
+// GormDataType stores amounts as text, sqlite integers overflow at 2^63.
+func (Wei) GormDataType() string {
+	return "text"
+}
+
+// Value implements driver.Valuer.
+func (w Wei) Value() (driver.Value, error) {
+	return w.String(), nil
+}
+
+// Scan implements sql.Scanner. Integer values of columns created before amounts were
+// stored as text are accepted as well.
+func (w *Wei) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*w = Wei{}
+	case int64:
+		*w = NewWei(v)
+	case string:
+		parsed, err := ParseWei(v)
+		if err != nil {
+			return err
+		}
+		*w = parsed
+	case []byte:
+		parsed, err := ParseWei(string(v))
+		if err != nil {
+			return err
+		}
+		*w = parsed
+	default:
+		return fmt.Errorf("cannot scan %T into Wei", value)
+	}
+	return nil
+}
+
+// MarshalJSON encodes the amount as a JSON number.
+func (w Wei) MarshalJSON() ([]byte, error) {
+	return []byte(w.String()), nil
+}
+
+// UnmarshalJSON decodes a JSON number or a decimal string. Exponents and fractions are
+// rejected rather than rounded.
+func (w *Wei) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "null" || s == "" {
+		*w = Wei{}
+		return nil
+	}
+	parsed, err := ParseWei(s)
+	if err != nil {
+		return err
+	}
+	*w = parsed
+	return nil
+}
@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// bigWei parses a decimal amount of wei, failing the test if it is invalid.
+func bigWei(t *testing.T, s string) Wei {
+	t.Helper()
+	w, err := ParseWei(s)
+	if err != nil {
+		t.Fatalf("failed to parse %q: %v", s, err)
+	}
+	return w
+}
+
+func TestWeiMulBasisPoints(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount string
+		bps    int64
+		want   string
+	}{
+		{"whole", "1000", BasisPoints, "1000"},
+		{"none", "1000", 0, "0"},
+		{"share", "1000", 7500, "750"},
+		{"rounds down", "999", 7500, "749"},
+		{"below one wei", "1", 9999, "0"},
+		{"zero value", "0", 7500, "0"},
+		{"negative truncates towards zero", "-999", 7500, "-749"},
+		{"beyond int64", "100000000000000000000", 2500, "25000000000000000000"},
+		{"beyond uint256", "1" + strings.Repeat("0", 80), 5000, "5" + strings.Repeat("0", 79)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := bigWei(t, tt.amount).MulBasisPoints(tt.bps)
+			if got.String() != tt.want {
+				t.Errorf("%s * %d bps = %s, want %s", tt.amount, tt.bps, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWeiMulBasisPointsZeroValue(t *testing.T) {
+	var w Wei
+	if got := w.MulBasisPoints(7500); got.Sign() != 0 {
+		t.Errorf("zero value * 7500 bps = %s, want 0", got)
+	}
+}
+
+func TestRateBasisPoints(t *testing.T) {
+	tests := []struct {
+		rate float64
+		want int64
+	}{
+		{0, 0},
+		{1, BasisPoints},
+		{0.25, 2500},
+		{0.1, 1000},
+		{0.12345, 1235},
+	}
+	for _, tt := range tests {
+		if got := RateBasisPoints(tt.rate); got != tt.want {
+			t.Errorf("RateBasisPoints(%v) = %d, want %d", tt.rate, got, tt.want)
+		}
+	}
+}
+
+func TestWeiScan(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    string
+		wantErr bool
+	}{
+		{name: "null", value: nil, want: "0"},
+		{name: "legacy int64 column", value: int64(1234567890123456789), want: "1234567890123456789"},
+		{name: "legacy negative int64 column", value: int64(-42), want: "-42"},
+		{name: "text", value: "123456789012345678901234567890", want: "123456789012345678901234567890"},
+		{name: "blob", value: []byte("98765432109876543210"), want: "98765432109876543210"},
+		{name: "text with spaces", value: " 42 ", want: "42"},
+		{name: "invalid text", value: "1e18", wantErr: true},
+		{name: "unsupported type", value: 1.5, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewWei(7)
+			err := w.Scan(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Scan(%v) = %s, want an error", tt.value, w)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Scan(%v) failed: %v", tt.value, err)
+			}
+			if w.String() != tt.want {
+				t.Errorf("Scan(%v) = %s, want %s", tt.value, w, tt.want)
+			}
+		})
+	}
+}
+
+func TestWeiValueRoundTrip(t *testing.T) {
+	// 2^128 does not fit in a sqlite integer.
+	want := WeiFromBig(new(big.Int).Lsh(big.NewInt(1), 128))
+	value, err := want.Value()
+	if err != nil {
+		t.Fatalf("Value failed: %v", err)
+	}
+	var got Wei
+	if err := got.Scan(value); err != nil {
+		t.Fatalf("Scan(%v) failed: %v", value, err)
+	}
+	if got.Cmp(want) != 0 {
+		t.Errorf("round trip of %s = %s", want, got)
+	}
+}
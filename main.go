@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
 	"github.com/Livepeer-Open-Pool/openpool-plugin/cmd"
 	log "github.com/sirupsen/logrus"
 	"os"
@@ -25,6 +26,8 @@ func main() {
 	configFileName := flag.String("config", "/etc/open-pool/config.json", "Open Pool Configuration file to use")
 	flag.Parse()
 
+	// Plugins read their manager specific settings from the same file.
+	internal.SetConfigFileName(*configFileName)
 	cmd.Run(*configFileName)
 }
 
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	"net/http"
+	"strconv"
+)
+
+// registerAdminHandlers exposes the payout loop's admin commands on the API server.
+func (p *PayoutLoopPlugin) registerAdminHandlers(adminToken string) {
+	http.HandleFunc("POST /admin/payouts/{id}/cancel", internal.RequireAdmin(adminToken, p.logger, p.handleCancelPayout))
+}
+
+// cancelResponse is the response of a payout cancellation.
+type cancelResponse struct {
+	CancelTxHash string `json:"cancelTxHash"`
+}
+
+// handleCancelPayout cancels a submitted payout. The payout stays submitted until the
+// cancellation is mined.
+func (p *PayoutLoopPlugin) handleCancelPayout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		internal.JSONError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := p.cancelPayout(id)
+	switch {
+	case err == nil:
+	case errors.Is(err, errPayoutNotFound):
+		internal.JSONError(w, "payout not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errPayoutNotSubmitted), errors.Is(err, errPayoutNonceUnknown):
+		internal.JSONError(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, errNotConnected):
+		internal.JSONError(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	default:
+		p.logger.WithError(err).Error("Failed to cancel payout")
+		internal.JSONError(w, fmt.Sprintf("failed to cancel payout: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(cancelResponse{CancelTxHash: tx.Hash().Hex()}); err != nil {
+		p.logger.WithError(err).Warn("Failed to encode /admin/payouts/{id}/cancel response")
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	log "github.com/sirupsen/logrus"
+	"math/big"
+	"strings"
+)
+
+// disperseABI is the function of the disperse contract batched payouts call. It sends
+// values[i] to recipients[i] out of the transaction's value.
+var disperseABI = mustParseABI(`[{
+	"name": "disperseEther",
+	"type": "function",
+	"stateMutability": "payable",
+	"inputs": [
+		{"name": "recipients", "type": "address[]"},
+		{"name": "values", "type": "uint256[]"}
+	],
+	"outputs": []
+}]`)
+
+func mustParseABI(definition string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(definition))
+	if err != nil {
+		panic(fmt.Sprintf("invalid ABI: %v", err))
+	}
+	return parsed
+}
+
+// payoutCall returns the recipient, value and input of the transaction paying payouts: a
+// transfer for a single payout without a contract, a call of the disperse contract for a
+// batch.
+func payoutCall(payouts []internal.PoolPayout) (common.Address, *big.Int, []byte, error) {
+	if len(payouts) == 0 {
+		return common.Address{}, nil, nil, fmt.Errorf("no payouts to send")
+	}
+	if payouts[0].Contract == "" {
+		if len(payouts) > 1 {
+			return common.Address{}, nil, nil, fmt.Errorf("%d payouts without a disperse contract", len(payouts))
+		}
+		return common.HexToAddress(payouts[0].EthAddress), payouts[0].Fees.Big(), nil, nil
+	}
+
+	recipients := make([]common.Address, len(payouts))
+	values := make([]*big.Int, len(payouts))
+	total := new(big.Int)
+	for i, payout := range payouts {
+		recipients[i] = common.HexToAddress(payout.EthAddress)
+		values[i] = payout.Fees.Big()
+		total.Add(total, values[i])
+	}
+	data, err := disperseABI.Pack("disperseEther", recipients, values)
+	if err != nil {
+		return common.Address{}, nil, nil, fmt.Errorf("failed to encode disperse call: %w", err)
+	}
+	return common.HexToAddress(payouts[0].Contract), total, data, nil
+}
+
+// payoutsLogger returns a logger with the worker of a single payout, or the size of a batch.
+func payoutsLogger(logger *log.Entry, payouts []internal.PoolPayout) *log.Entry {
+	if len(payouts) == 1 {
+		return logger.WithFields(log.Fields{
+			"workerAddr": payouts[0].EthAddress,
+			"payoutAmt":  payouts[0].Fees.String(),
+		})
+	}
+	total := internal.Wei{}
+	for _, payout := range payouts {
+		total = total.Add(payout.Fees)
+	}
+	return logger.WithFields(log.Fields{
+		"payouts":   len(payouts),
+		"payoutAmt": total.String(),
+	})
+}
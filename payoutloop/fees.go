@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"math/big"
+)
+
+// errEstimateGas is returned when the gas of a transaction cannot be estimated, which
+// usually means it would revert.
+var errEstimateGas = errors.New("failed to estimate gas")
+
+// errGasLimitExceeded is returned when the estimated gas of a transaction exceeds
+// MaxGasLimit.
+var errGasLimitExceeded = errors.New("estimated gas exceeds max gas limit")
+
+// errFeeLimitExceeded is returned when bumping the fees of a stuck transaction would exceed
+// MaxFeePerGas or MaxPriorityFeePerGas. The fees only ever grow, so it cannot be replaced
+// until the limits are raised.
+var errFeeLimitExceeded = errors.New("bumped fees exceed the max fees per gas")
+
+// txFees are the max fee and priority fee per gas of a dynamic fee transaction.
+type txFees struct {
+	feeCap *big.Int
+	tipCap *big.Int
+}
+
+// suggestFees returns the fees of a new payout transaction: the suggested priority fee up
+// to MaxPriorityFeePerGas, and a max fee of twice the base fee plus the priority fee, up to
+// MaxFeePerGas.
+func (p *PayoutLoopPlugin) suggestFees(ctx context.Context) (txFees, error) {
+	tipCap, err := p.client.SuggestGasTipCap(ctx)
+	if err != nil {
+		return txFees{}, fmt.Errorf("failed to suggest priority fee: %w", err)
+	}
+	if tipCap.Cmp(p.maxPriorityFeePerGas) > 0 {
+		tipCap = new(big.Int).Set(p.maxPriorityFeePerGas)
+	}
+
+	head, err := p.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return txFees{}, fmt.Errorf("failed to get the latest block: %w", err)
+	}
+	if head.BaseFee == nil {
+		return txFees{}, fmt.Errorf("block %v has no base fee, dynamic fee transactions are not supported", head.Number)
+	}
+	if head.BaseFee.Cmp(p.maxFeePerGas) > 0 {
+		return txFees{}, fmt.Errorf("base fee %v exceeds max fee per gas %v", head.BaseFee, p.maxFeePerGas)
+	}
+
+	// Twice the base fee keeps the transaction minable through several full blocks.
+	feeCap := new(big.Int).Add(new(big.Int).Mul(head.BaseFee, big.NewInt(2)), tipCap)
+	if feeCap.Cmp(p.maxFeePerGas) > 0 {
+		feeCap = new(big.Int).Set(p.maxFeePerGas)
+	}
+	if tipCap.Cmp(feeCap) > 0 {
+		tipCap = new(big.Int).Set(feeCap)
+	}
+	return txFees{feeCap: feeCap, tipCap: tipCap}, nil
+}
+
+// replacementFees returns the fees of a transaction replacing one sent with previous: the
+// suggested fees, but both at least FeeBumpPercent above the previous ones.
+func (p *PayoutLoopPlugin) replacementFees(ctx context.Context, previous txFees) (txFees, error) {
+	fees, err := p.suggestFees(ctx)
+	if err != nil {
+		return txFees{}, err
+	}
+	if bumped := p.bump(previous.feeCap); bumped.Cmp(fees.feeCap) > 0 {
+		fees.feeCap = bumped
+	}
+	if bumped := p.bump(previous.tipCap); bumped.Cmp(fees.tipCap) > 0 {
+		fees.tipCap = bumped
+	}
+	if fees.feeCap.Cmp(p.maxFeePerGas) > 0 {
+		return txFees{}, fmt.Errorf("%w: max fee per gas %v of the replacement exceeds %v", errFeeLimitExceeded, fees.feeCap, p.maxFeePerGas)
+	}
+	if fees.tipCap.Cmp(p.maxPriorityFeePerGas) > 0 {
+		return txFees{}, fmt.Errorf("%w: priority fee per gas %v of the replacement exceeds %v", errFeeLimitExceeded, fees.tipCap, p.maxPriorityFeePerGas)
+	}
+	return fees, nil
+}
+
+// bump raises a fee by FeeBumpPercent, rounded up so that small fees are raised as well.
+func (p *PayoutLoopPlugin) bump(fee *big.Int) *big.Int {
+	bumped := new(big.Int).Mul(fee, big.NewInt(100+p.feeBumpPercent))
+	return bumped.Add(bumped, big.NewInt(99)).Div(bumped, big.NewInt(100))
+}
+
+// signTx creates and signs a dynamic fee transaction with the given nonce and fees. Its gas
+// is estimated, up to MaxGasLimit.
+func (p *PayoutLoopPlugin) signTx(ctx context.Context, nonce uint64, to common.Address, amount *big.Int, data []byte, fees txFees) (*types.Transaction, error) {
+	from := crypto.PubkeyToAddress(p.privateKey.PublicKey)
+	gas, err := p.client.EstimateGas(ctx, ethereum.CallMsg{
+		From:      from,
+		To:        &to,
+		Value:     amount,
+		Data:      data,
+		GasFeeCap: fees.feeCap,
+		GasTipCap: fees.tipCap,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errEstimateGas, err)
+	}
+	if gas > p.maxGasLimit {
+		return nil, fmt.Errorf("%w: %d > %d", errGasLimitExceeded, gas, p.maxGasLimit)
+	}
+
+	// Get the network's chain ID.
+	chainID, err := p.client.ChainID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chain ID: %w", err)
+	}
+
+	tx := types.NewTx(&types.DynamicFeeTx{
+		ChainID:   chainID,
+		Nonce:     nonce,
+		GasTipCap: fees.tipCap,
+		GasFeeCap: fees.feeCap,
+		Gas:       gas,
+		To:        &to,
+		Value:     amount,
+		Data:      data,
+	})
+	signedTx, err := types.SignTx(tx, types.LatestSignerForChainID(chainID), p.privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign transaction: %w", err)
+	}
+	return signedTx, nil
+}
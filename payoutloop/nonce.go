@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	"github.com/ethereum/go-ethereum/common"
+	log "github.com/sirupsen/logrus"
+)
+
+// nonceManager allocates the nonces of payout transactions locally, so the payouts of one
+// cycle do not depend on the node's view of its mempool. The nonces in flight are those of
+// the submitted payouts, which are stored before their transaction is sent. It is guarded
+// by the plugin's sendMu.
+type nonceManager struct {
+	client ethClient
+	store  internal.Store
+	from   common.Address
+	next   uint64
+	synced bool
+	logger *log.Entry
+}
+
+// sync resyncs the next nonce with the chain: the node's pending nonce, or the nonce after
+// the highest one of the submitted payouts if that is higher. The nonces of payouts the
+// node dropped stay reserved until the tracker replaces or fails them.
+func (m *nonceManager) sync(ctx context.Context) error {
+	pending, err := m.client.PendingNonceAt(ctx, m.from)
+	if err != nil {
+		return fmt.Errorf("failed to get nonce: %w", err)
+	}
+	payouts, err := m.store.GetPayouts(internal.PayoutSubmitted)
+	if err != nil {
+		return fmt.Errorf("failed to fetch submitted payouts: %w", err)
+	}
+
+	next := pending
+	for _, payout := range payouts {
+		if payout.Nonce != nil && *payout.Nonce >= next {
+			next = *payout.Nonce + 1
+		}
+	}
+	m.logger.WithFields(log.Fields{
+		"pendingNonce": pending,
+		"nextNonce":    next,
+		"inFlight":     len(payouts),
+	}).Info("Synced payout nonce with the chain")
+	m.next = next
+	m.synced = true
+	return nil
+}
+
+// allocate returns the nonce of the next payout transaction, resyncing first if needed.
+func (m *nonceManager) allocate(ctx context.Context) (uint64, error) {
+	if !m.synced {
+		if err := m.sync(ctx); err != nil {
+			return 0, err
+		}
+	}
+	nonce := m.next
+	m.next++
+	return nonce, nil
+}
+
+// invalidate makes the next allocation resync with the chain, after a payout could not be
+// recorded or sent.
+func (m *nonceManager) invalidate() {
+	m.synced = false
+}
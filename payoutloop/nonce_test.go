@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"math/big"
+	"testing"
+)
+
+func TestNonceSyncKeepsInFlightPayouts(t *testing.T) {
+	p, store, _ := newTestPlugin(t)
+	nonce := uint64(3)
+	payout := duePayout(workerAddress(1), 1e15)
+	payout.Nonce = &nonce
+	if _, err := store.RecordPayouts([]internal.PoolPayout{payout}); err != nil {
+		t.Fatalf("failed to record payout: %v", err)
+	}
+
+	next, err := p.nonces.allocate(context.Background())
+	if err != nil {
+		t.Fatalf("failed to allocate nonce: %v", err)
+	}
+	if next != 4 {
+		t.Errorf("allocated nonce %d, want 4 after the submitted payout", next)
+	}
+}
+
+func TestNonceResyncAfterExternalTransaction(t *testing.T) {
+	p, store, backend := newTestPlugin(t)
+	ctx := context.Background()
+	if err := p.nonces.sync(ctx); err != nil {
+		t.Fatalf("failed to sync nonce: %v", err)
+	}
+
+	// Another transaction of the pool's account uses the nonce the payout loop holds next.
+	from := crypto.PubkeyToAddress(p.privateKey.PublicKey)
+	chainID, err := backend.Client().ChainID(ctx)
+	if err != nil {
+		t.Fatalf("failed to get chain ID: %v", err)
+	}
+	external, err := types.SignNewTx(p.privateKey, types.LatestSignerForChainID(chainID), &types.DynamicFeeTx{
+		ChainID:   chainID,
+		Nonce:     0,
+		GasTipCap: big.NewInt(1e9),
+		GasFeeCap: big.NewInt(1e10),
+		Gas:       21000,
+		To:        &from,
+		Value:     new(big.Int),
+	})
+	if err != nil {
+		t.Fatalf("failed to sign transaction: %v", err)
+	}
+	if err := backend.Client().SendTransaction(ctx, external); err != nil {
+		t.Fatalf("failed to send transaction: %v", err)
+	}
+	backend.Commit()
+
+	worker := workerAddress(1)
+	if err := p.pay([]internal.PoolPayout{duePayout(worker, 1e15)}); err == nil {
+		t.Fatal("payout with a used nonce was sent")
+	}
+	if err := p.pay([]internal.PoolPayout{duePayout(worker, 1e15)}); err != nil {
+		t.Fatalf("payout after the resync failed: %v", err)
+	}
+	backend.Commit()
+	p.checkPayouts()
+
+	payouts := store.all()
+	if len(payouts) != 2 {
+		t.Fatalf("got %d payouts, want 2", len(payouts))
+	}
+	assertStatus(t, payouts[:1], internal.PayoutFailed)
+	assertStatus(t, payouts[1:], internal.PayoutConfirmed)
+	if *payouts[1].Nonce != 1 {
+		t.Errorf("payout after the resync has nonce %d, want 1", *payouts[1].Nonce)
+	}
+	assertBalance(t, backend, worker, 1e15)
+}
@@ -4,9 +4,12 @@ import (
 	"context"
 	"crypto/ecdsa"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
 	pool "github.com/Livepeer-Open-Pool/openpool-plugin"
 	"github.com/Livepeer-Open-Pool/openpool-plugin/config"
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/keystore"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -17,18 +20,54 @@ import (
 	"io/ioutil"
 	"math/big"
 	"net/http"
+	"sync"
 	"time"
 )
 
 type PayoutLoopPlugin struct {
-	store             pool.StorageInterface
+	store             internal.Store
 	region            string
 	rpcUrl            string
 	keyPath           string
 	keyPassphrasePath string
 	payoutThreshold   *big.Int
 	payoutFrequency   int
-	logger            *log.Entry
+	// confirmationDepth and receiptPoll configure the tracking of submitted payouts.
+	confirmationDepth uint64
+	receiptPoll       time.Duration
+	// stuckTimeout and feeBumpPercent configure the replacement of unmined payouts.
+	stuckTimeout   time.Duration
+	feeBumpPercent int64
+	// maxFeePerGas, maxPriorityFeePerGas and maxGasLimit cap the fees of payout transactions.
+	maxFeePerGas         *big.Int
+	maxPriorityFeePerGas *big.Int
+	maxGasLimit          uint64
+	// disperseContract, when set, batches the payouts of a cycle into calls of its
+	// disperseEther, with at most maxBatchSize workers each.
+	disperseContract string
+	maxBatchSize     int
+	// sendMu serializes the payout cycle, the tracker and cancellations, so replacements
+	// and cancellations act on the current payouts. It guards client, privateKey and nonces.
+	sendMu     sync.Mutex
+	client     ethClient
+	privateKey *ecdsa.PrivateKey
+	nonces     *nonceManager
+	logger     *log.Entry
+}
+
+// ethClient is the part of the Ethereum RPC client the payout loop uses. Both
+// *ethclient.Client and the client of go-ethereum's simulated backend implement it.
+type ethClient interface {
+	BlockNumber(ctx context.Context) (uint64, error)
+	ChainID(ctx context.Context) (*big.Int, error)
+	EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+	SendTransaction(ctx context.Context, tx *types.Transaction) error
+	SuggestGasTipCap(ctx context.Context) (*big.Int, error)
+	TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error)
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
 }
 
 // Ensure PayoutLoopPlugin implements PluginInterface ✅
@@ -52,7 +91,11 @@ func (p *PayoutLoopPlugin) Init(cfg config.Config, store pool.StorageInterface)
 
 	p.logger.Info("Initializing PayoutLoopPlugin")
 
-	p.store = store
+	extStore, ok := store.(internal.Store)
+	if !ok {
+		p.logger.Fatal("Storage plugin does not implement the manager storage interface")
+	}
+	p.store = extStore
 	p.region = cfg.Region
 	p.rpcUrl = cfg.PayoutLoopConfig.RPCUrl
 	p.payoutFrequency = cfg.PayoutLoopConfig.PayoutFrequencySeconds
@@ -60,12 +103,44 @@ func (p *PayoutLoopPlugin) Init(cfg config.Config, store pool.StorageInterface)
 	p.keyPath = cfg.PayoutLoopConfig.PrivateKeyStorePath
 	p.keyPassphrasePath = cfg.PayoutLoopConfig.PrivateKeyPassphrasePath
 
+	extCfg, err := internal.LoadConfig()
+	if err != nil {
+		p.logger.WithError(err).Fatal("Failed to load payout loop config")
+	}
+	p.confirmationDepth = uint64(extCfg.PayoutLoopConfig.ConfirmationDepth)
+	p.receiptPoll = time.Duration(extCfg.PayoutLoopConfig.ReceiptPollSeconds) * time.Second
+	p.stuckTimeout = time.Duration(extCfg.PayoutLoopConfig.StuckTimeoutSeconds) * time.Second
+	p.feeBumpPercent = int64(extCfg.PayoutLoopConfig.FeeBumpPercent)
+	p.maxFeePerGas = extCfg.PayoutLoopConfig.MaxFeePerGas.Big()
+	p.maxPriorityFeePerGas = extCfg.PayoutLoopConfig.MaxPriorityFeePerGas.Big()
+	p.maxGasLimit = extCfg.PayoutLoopConfig.MaxGasLimit
+	if contract := extCfg.PayoutLoopConfig.DisperseContract; contract != "" {
+		if !common.IsHexAddress(contract) {
+			p.logger.WithField("disperseContract", contract).Fatal("Invalid disperse contract address")
+		}
+		p.disperseContract = common.HexToAddress(contract).Hex()
+	}
+	p.maxBatchSize = extCfg.PayoutLoopConfig.MaxBatchSize
+	p.registerAdminHandlers(extCfg.APIConfig.AdminToken)
+	if !internal.ServesAdminRoutes(cfg) {
+		p.logger.Warn("API plugin is not configured, the payout loop admin endpoints are not served")
+	}
+
 	p.logger.WithFields(log.Fields{
 		"rpcUrl":            p.rpcUrl,
 		"payoutFrequency":   p.payoutFrequency,
 		"payoutThreshold":   p.payoutThreshold.String(),
 		"keyPath":           p.keyPath,
 		"keyPassphrasePath": p.keyPassphrasePath,
+		"confirmationDepth": p.confirmationDepth,
+		"receiptPoll":       p.receiptPoll,
+		"stuckTimeout":      p.stuckTimeout,
+		"feeBumpPercent":    p.feeBumpPercent,
+		"maxFeePerGas":      p.maxFeePerGas.String(),
+		"maxPriorityFee":    p.maxPriorityFeePerGas.String(),
+		"maxGasLimit":       p.maxGasLimit,
+		"disperseContract":  p.disperseContract,
+		"maxBatchSize":      p.maxBatchSize,
 	}).Info("PayoutLoopPlugin configuration loaded")
 }
 
@@ -74,56 +149,38 @@ func (p *PayoutLoopPlugin) Start() {
 	p.logger.WithField("payoutFrequency", p.payoutFrequency).
 		Info("Payout Loop started")
 
+	client, privateKey := p.connect()
+	p.sendMu.Lock()
+	p.client = client
+	p.privateKey = privateKey
+	p.nonces = &nonceManager{
+		client: client,
+		store:  p.store,
+		from:   crypto.PubkeyToAddress(privateKey.PublicKey),
+		logger: p.logger,
+	}
+	if err := p.nonces.sync(context.Background()); err != nil {
+		p.logger.WithError(err).Warn("Failed to sync payout nonce, retrying on the first payout")
+	}
+	p.sendMu.Unlock()
+	go p.trackPayouts()
+
 	for {
 		p.logger.Debug("Fetching all workers for potential payout...")
 
-		workers, err := p.store.GetWorkers()
+		// The remote workers carry the balances as big integers, the shared Worker only as int64.
+		workers, err := p.store.GetRemoteWorkers()
 		if err != nil {
 			p.logger.WithError(err).Error("Error fetching workers from store")
 			time.Sleep(time.Duration(p.payoutFrequency) * time.Second)
 			continue
 		}
 
-		// Connect to an Ethereum node.
-		customTransport := &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		}
-
-		customClient := &http.Client{Transport: customTransport}
-
-		// Use rpc.DialOptions instead of ethclient.Dial
-		rpcClient, err := rpc.DialOptions(context.Background(), p.rpcUrl, rpc.WithHTTPClient(customClient))
-		if err != nil {
-			p.logger.WithError(err).Fatal("Failed to connect to the Ethereum client")
-		}
-
-		// Wrap the RPC client into an ethclient
-		client := ethclient.NewClient(rpcClient)
-
-		// Load the JSON keystore file.
-		keyJSON, err := ioutil.ReadFile(p.keyPath)
-		if err != nil {
-			p.logger.WithError(err).Fatal("Failed to read keystore file")
-		}
-
-		// Decrypt the key using your keystore passphrase.
-		passphrase, err := ioutil.ReadFile(p.keyPassphrasePath)
-		if err != nil {
-			p.logger.WithError(err).Fatal("Failed to read passphrase file")
-		}
-
-		key, err := keystore.DecryptKey(keyJSON, string(passphrase))
-		if err != nil {
-			p.logger.WithError(err).Fatal("Failed to decrypt keystore")
-		}
-		privateKey := key.PrivateKey
-
+		var due []internal.PoolPayout
 		for _, worker := range workers {
-			//nodeType := worker.GetNodeType()
 			region := worker.GetRegion()
 			ethAddress := worker.GetID()
-			// Assume worker.PendingFees is stored in wei as an int64.
-			pendingFees := new(big.Int).SetInt64(worker.GetPendingFees())
+			pendingFees := worker.PendingFees.Big()
 			// Log at debug level with context
 			p.logger.WithFields(log.Fields{
 				"workerAddr":   ethAddress,
@@ -134,102 +191,184 @@ func (p *PayoutLoopPlugin) Start() {
 			}).Debug("Checking if worker fees exceed threshold")
 
 			if pendingFees.Cmp(p.payoutThreshold) >= 0 {
-				payoutAmount := pendingFees
-				recipient := common.HexToAddress(ethAddress)
 				p.logger.WithFields(log.Fields{
 					"workerAddr":   ethAddress,
 					"pendingFees":  pendingFees.String(),
-					"payoutAmount": payoutAmount.String(),
+					"payoutAmount": pendingFees.String(),
 				}).Info("Threshold reached, initiating payout")
-
-				// Send the payout and capture the transaction hash.
-				txHash, err := SendEth(client, privateKey, payoutAmount, recipient)
-				if err != nil {
-					p.logger.WithFields(log.Fields{
-						"workerAddr": ethAddress,
-						"payoutAmt":  payoutAmount.String(),
-					}).WithError(err).Error("Failed to send payout")
-					continue
-				}
-				// Record the payout
-				p.logger.WithFields(log.Fields{
-					"workerAddr":   ethAddress,
-					"txHash":       txHash.Hex(),
-					"payoutAmount": payoutAmount.String(),
-				}).Info("Payout sent, creating pool payout record")
-
-				if err := p.store.AddPaidFees(ethAddress, payoutAmount.Int64(), txHash.Hex(), p.region, worker.GetNodeType()); err != nil {
-					p.logger.WithFields(log.Fields{
-						"workerAddr": ethAddress,
-						"txHash":     txHash.Hex(),
-					}).WithError(err).Error("Failed to create pool payout record")
-				} else {
-					p.logger.WithFields(log.Fields{
-						"workerAddr":   ethAddress,
-						"payoutAmount": payoutAmount.String(),
-						"txHash":       txHash.Hex(),
-					}).Info("Payout recorded successfully")
-				}
+				due = append(due, internal.PoolPayout{
+					EthAddress: ethAddress,
+					NodeType:   worker.GetNodeType(),
+					Region:     p.region,
+					Fees:       worker.PendingFees,
+				})
 			}
 		}
+		p.payWorkers(due)
 
 		p.logger.Debug("Payout cycle complete, sleeping")
 		time.Sleep(time.Duration(p.payoutFrequency) * time.Second)
 	}
 }
 
-// SendEth sends a specified amount of ETH to a recipient address and returns the transaction hash.
-func SendEth(client *ethclient.Client, privateKey *ecdsa.PrivateKey, amount *big.Int, to common.Address) (common.Hash, error) {
-	ctx := context.Background()
+// connect connects to the Ethereum node and loads the pool's private key.
+func (p *PayoutLoopPlugin) connect() (*ethclient.Client, *ecdsa.PrivateKey) {
+	// Connect to an Ethereum node.
+	customTransport := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
 
-	// Derive the sender address from the private key.
-	from := crypto.PubkeyToAddress(privateKey.PublicKey)
+	customClient := &http.Client{Transport: customTransport}
 
-	// Retrieve the next available nonce for the sender.
-	nonce, err := client.PendingNonceAt(ctx, from)
+	// Use rpc.DialOptions instead of ethclient.Dial
+	rpcClient, err := rpc.DialOptions(context.Background(), p.rpcUrl, rpc.WithHTTPClient(customClient))
 	if err != nil {
-		return common.Hash{}, fmt.Errorf("failed to get nonce: %v", err)
+		p.logger.WithError(err).Fatal("Failed to connect to the Ethereum client")
 	}
 
-	// Set a fixed gas limit.
-	gasLimit := uint64(1_000_000)
+	// Wrap the RPC client into an ethclient
+	client := ethclient.NewClient(rpcClient)
 
-	// Get the current suggested gas price.
-	gasPrice, err := client.SuggestGasPrice(ctx)
+	// Load the JSON keystore file.
+	keyJSON, err := ioutil.ReadFile(p.keyPath)
 	if err != nil {
-		return common.Hash{}, fmt.Errorf("failed to suggest gas price: %v", err)
+		p.logger.WithError(err).Fatal("Failed to read keystore file")
 	}
 
-	// Define the maximum acceptable gas price.
-	maxGasPrice, ok := new(big.Int).SetString("5000000000000", 10)
-	if !ok {
-		return common.Hash{}, fmt.Errorf("invalid gas price threshold")
+	// Decrypt the key using your keystore passphrase.
+	passphrase, err := ioutil.ReadFile(p.keyPassphrasePath)
+	if err != nil {
+		p.logger.WithError(err).Fatal("Failed to read passphrase file")
 	}
-	if gasPrice.Cmp(maxGasPrice) > 0 {
-		return common.Hash{}, fmt.Errorf("gas price %v exceeds threshold %v", gasPrice, maxGasPrice)
+
+	key, err := keystore.DecryptKey(keyJSON, string(passphrase))
+	if err != nil {
+		p.logger.WithError(err).Fatal("Failed to decrypt keystore")
+	}
+	return client, key.PrivateKey
+}
+
+// payWorkers sends the due payouts of a cycle, in batches through the disperse contract if
+// one is configured.
+func (p *PayoutLoopPlugin) payWorkers(due []internal.PoolPayout) {
+	if p.disperseContract == "" {
+		for _, payout := range due {
+			p.pay([]internal.PoolPayout{payout})
+		}
+		return
+	}
+
+	for start := 0; start < len(due); start += p.maxBatchSize {
+		batch := make([]internal.PoolPayout, 0, p.maxBatchSize)
+		for _, payout := range due[start:min(start+p.maxBatchSize, len(due))] {
+			payout.Contract = p.disperseContract
+			batch = append(batch, payout)
+		}
+		p.payBatch(batch)
+	}
+}
+
+// payBatch sends a batch of payouts through the disperse contract. A batch whose gas cannot
+// be estimated, e.g. because one of its workers rejects the transfer, or exceeds MaxGasLimit
+// is split in halves that are sent the same way, down to plain transfers to single workers.
+func (p *PayoutLoopPlugin) payBatch(batch []internal.PoolPayout) {
+	if len(batch) == 1 {
+		payout := batch[0]
+		payout.Contract = ""
+		p.pay([]internal.PoolPayout{payout})
+		return
+	}
+
+	err := p.pay(batch)
+	if !errors.Is(err, errEstimateGas) && !errors.Is(err, errGasLimitExceeded) {
+		return
+	}
+	half := len(batch) / 2
+	p.logger.WithFields(log.Fields{
+		"payouts": len(batch),
+		"halves":  []int{half, len(batch) - half},
+	}).Warn("Splitting the batch")
+	p.payBatch(batch[:half])
+	p.payBatch(batch[half:])
+}
+
+// pay sends the payouts of one transaction and logs the outcome.
+func (p *PayoutLoopPlugin) pay(payouts []internal.PoolPayout) error {
+	payoutLogger := payoutsLogger(p.logger, payouts)
+
+	// Send the payout and capture the transaction.
+	p.sendMu.Lock()
+	tx, err := p.sendPayouts(payouts)
+	p.sendMu.Unlock()
+	if err != nil {
+		payoutLogger.WithError(err).Error("Failed to send payout")
+		return err
 	}
+	payoutLogger.WithFields(log.Fields{
+		"txHash": tx.Hash().Hex(),
+		"nonce":  tx.Nonce(),
+	}).Info("Payout sent")
+	return nil
+}
 
-	// Create the transaction.
-	tx := types.NewTransaction(nonce, to, amount, gasLimit, gasPrice, nil)
+// sendPayouts pays the pending fees of the payouts in one transaction and returns it. The
+// payouts are recorded with its nonce before the transaction is sent, so the nonce stays in
+// flight if the loop stops in between. If the node did not take the transaction, the
+// payouts fail and their amounts go back to the workers' pending fees.
+func (p *PayoutLoopPlugin) sendPayouts(payouts []internal.PoolPayout) (*types.Transaction, error) {
+	ctx := context.Background()
 
-	// Get the network's chain ID.
-	chainID, err := client.NetworkID(ctx)
+	fees, err := p.suggestFees(ctx)
+	if err != nil {
+		return nil, err
+	}
+	to, value, data, err := payoutCall(payouts)
 	if err != nil {
-		return common.Hash{}, fmt.Errorf("failed to get chain ID: %v", err)
+		return nil, err
 	}
 
-	// Sign the transaction using EIP-155.
-	signedTx, err := types.SignTx(tx, types.NewEIP155Signer(chainID), privateKey)
+	nonce, err := p.nonces.allocate(ctx)
 	if err != nil {
-		return common.Hash{}, fmt.Errorf("failed to sign transaction: %v", err)
+		return nil, err
+	}
+	signedTx, err := p.signTx(ctx, nonce, to, value, data, fees)
+	if err != nil {
+		p.nonces.invalidate()
+		return nil, err
+	}
+
+	// The payouts stay submitted until the tracker sees their receipt confirmed.
+	records := make([]internal.PoolPayout, len(payouts))
+	for i, payout := range payouts {
+		payout.TxHash = signedTx.Hash().Hex()
+		payout.Nonce = &nonce
+		payout.GasFeeCap = internal.WeiFromBig(fees.feeCap)
+		payout.GasTipCap = internal.WeiFromBig(fees.tipCap)
+		records[i] = payout
+	}
+	ids, err := p.store.RecordPayouts(records)
+	if err != nil {
+		p.nonces.invalidate()
+		return nil, fmt.Errorf("failed to create pool payout records: %w", err)
 	}
 
 	// Send the signed transaction.
-	if err := client.SendTransaction(ctx, signedTx); err != nil {
-		return common.Hash{}, fmt.Errorf("failed to send transaction: %v", err)
+	if err := p.client.SendTransaction(ctx, signedTx); err != nil {
+		p.nonces.invalidate()
+		// The error may have come after the node took the transaction, which the tracker
+		// follows like any other.
+		if _, _, lookupErr := p.client.TransactionByHash(ctx, signedTx.Hash()); !errors.Is(lookupErr, ethereum.NotFound) {
+			return nil, fmt.Errorf("failed to send transaction, it may still be mined: %v", err)
+		}
+		for _, id := range ids {
+			if failErr := p.store.FailPayout(id, fmt.Sprintf("failed to send transaction: %v", err)); failErr != nil {
+				p.logger.WithField("payoutID", id).WithError(failErr).Error("Failed to fail unsent payout")
+			}
+		}
+		return nil, fmt.Errorf("failed to send transaction: %v", err)
 	}
 
-	return signedTx.Hash(), nil
+	return signedTx, nil
 }
 
 // Exported symbol for plugin loading
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient/simulated"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+)
+
+// disperseCode is the runtime code of a minimal disperse contract. Whatever function is
+// called, it sends values[i] to recipients[i] for the disperseEther(address[], uint256[])
+// arguments and reverts if a transfer fails:
+//
+//	r := 4 + calldataload(4); v := 4 + calldataload(36)
+//	for i := 0; i < calldataload(r); i++ {
+//		if !call(gas, calldataload(r+32+32*i), calldataload(v+32+32*i), 0, 0, 0, 0) { revert(0, 0) }
+//	}
+const disperseCode = "0x60043560040160243560040160005b823581101561004257600060006000600084602002860160200135856020028801602001355af1156100445760010161000e565b005b600080fd"
+
+// rejectingCode is the runtime code of a recipient that rejects every transfer.
+const rejectingCode = "0x60006000fd"
+
+var (
+	disperseAddress  = common.HexToAddress("0xd15be00000000000000000000000000000000001")
+	rejectingAddress = common.HexToAddress("0xbad0000000000000000000000000000000000001")
+)
+
+// workerAddress returns the address of the i-th test worker, which never received ETH.
+func workerAddress(i int) common.Address {
+	return crypto.CreateAddress(common.Address{}, uint64(i))
+}
+
+// duePayout returns the payout of fees to a worker as the payout cycle creates it.
+func duePayout(worker common.Address, fees int64) internal.PoolPayout {
+	return internal.PoolPayout{
+		EthAddress: worker.Hex(),
+		NodeType:   "transcode",
+		Region:     "test",
+		Fees:       internal.NewWei(fees),
+	}
+}
+
+// newTestPlugin returns a payout loop connected to a simulated chain on which the pool's
+// account holds 100 ETH and the disperse contract and a rejecting recipient are deployed.
+func newTestPlugin(t *testing.T) (*PayoutLoopPlugin, *memStore, *simulated.Backend) {
+	t.Helper()
+
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	backend := simulated.NewBackend(types.GenesisAlloc{
+		from:             {Balance: new(big.Int).Mul(big.NewInt(100), big.NewInt(1e18))},
+		disperseAddress:  {Code: common.FromHex(disperseCode)},
+		rejectingAddress: {Code: common.FromHex(rejectingCode)},
+	})
+	t.Cleanup(func() { backend.Close() })
+	// The node reports receipts as being indexed, not missing, until it indexed a block.
+	backend.Commit()
+
+	logger := log.New()
+	logger.SetOutput(io.Discard)
+	store := &memStore{}
+	p := &PayoutLoopPlugin{
+		store:                store,
+		region:               "test",
+		confirmationDepth:    1,
+		stuckTimeout:         time.Hour,
+		feeBumpPercent:       internal.DefaultFeeBumpPercent,
+		maxFeePerGas:         big.NewInt(internal.DefaultMaxFeePerGas),
+		maxPriorityFeePerGas: big.NewInt(internal.DefaultMaxPriorityFeePerGas),
+		maxGasLimit:          internal.DefaultMaxGasLimit,
+		disperseContract:     disperseAddress.Hex(),
+		maxBatchSize:         internal.DefaultMaxBatchSize,
+		client:               backend.Client(),
+		privateKey:           key,
+		logger:               log.NewEntry(logger),
+	}
+	p.nonces = &nonceManager{
+		client: p.client,
+		store:  store,
+		from:   from,
+		logger: p.logger,
+	}
+	return p, store, backend
+}
+
+// assertBalance fails the test if the balance of account is not want.
+func assertBalance(t *testing.T, backend *simulated.Backend, account common.Address, want int64) {
+	t.Helper()
+	balance, err := backend.Client().BalanceAt(context.Background(), account, nil)
+	if err != nil {
+		t.Fatalf("failed to get balance of %s: %v", account.Hex(), err)
+	}
+	if balance.Cmp(big.NewInt(want)) != 0 {
+		t.Errorf("balance of %s = %v, want %d", account.Hex(), balance, want)
+	}
+}
+
+// assertStatus fails the test if the payouts do not have the status.
+func assertStatus(t *testing.T, payouts []internal.PoolPayout, status string) {
+	t.Helper()
+	for _, payout := range payouts {
+		if payout.Status != status {
+			t.Errorf("payout %d to %s is %s (%s), want %s", payout.ID, payout.EthAddress, payout.Status, payout.Error, status)
+		}
+	}
+}
+
+func TestPayWorkersSingleTransfer(t *testing.T) {
+	p, store, backend := newTestPlugin(t)
+	p.disperseContract = ""
+	worker := workerAddress(1)
+
+	p.payWorkers([]internal.PoolPayout{duePayout(worker, 1e15)})
+	backend.Commit()
+	p.checkPayouts()
+
+	payouts := store.all()
+	if len(payouts) != 1 {
+		t.Fatalf("got %d payouts, want 1", len(payouts))
+	}
+	assertStatus(t, payouts, internal.PayoutConfirmed)
+	if payouts[0].Contract != "" || payouts[0].Nonce == nil || *payouts[0].Nonce != 0 {
+		t.Errorf("payout = %+v, want a transfer with nonce 0", payouts[0])
+	}
+	assertBalance(t, backend, worker, 1e15)
+}
+
+func TestPayWorkersBatch(t *testing.T) {
+	p, store, backend := newTestPlugin(t)
+	var due []internal.PoolPayout
+	for i := 1; i <= 3; i++ {
+		due = append(due, duePayout(workerAddress(i), int64(i)*1e15))
+	}
+
+	p.payWorkers(due)
+	backend.Commit()
+	p.checkPayouts()
+
+	payouts := store.all()
+	if len(payouts) != 3 {
+		t.Fatalf("got %d payouts, want 3", len(payouts))
+	}
+	assertStatus(t, payouts, internal.PayoutConfirmed)
+	for _, payout := range payouts {
+		if payout.TxHash != payouts[0].TxHash || payout.Contract != disperseAddress.Hex() {
+			t.Errorf("payout %d = %+v, want it in the disperse transaction %s", payout.ID, payout, payouts[0].TxHash)
+		}
+	}
+	for i := 1; i <= 3; i++ {
+		assertBalance(t, backend, workerAddress(i), int64(i)*1e15)
+	}
+}
+
+func TestPayWorkersFallsBackToSingleTransfers(t *testing.T) {
+	p, store, backend := newTestPlugin(t)
+	due := []internal.PoolPayout{
+		duePayout(workerAddress(1), 1e15),
+		duePayout(rejectingAddress, 1e15),
+		duePayout(workerAddress(2), 1e15),
+		duePayout(workerAddress(3), 1e15),
+	}
+
+	p.payWorkers(due)
+	backend.Commit()
+	p.checkPayouts()
+
+	// The batch is split until the rejecting recipient is alone, whose payout is never sent.
+	payouts := store.all()
+	if len(payouts) != 3 {
+		t.Fatalf("got %d payouts, want 3", len(payouts))
+	}
+	assertStatus(t, payouts, internal.PayoutConfirmed)
+	for _, payout := range payouts {
+		if payout.EthAddress == rejectingAddress.Hex() {
+			t.Errorf("payout %d to the rejecting recipient was recorded", payout.ID)
+		}
+	}
+	for i := 1; i <= 3; i++ {
+		assertBalance(t, backend, workerAddress(i), 1e15)
+	}
+	assertBalance(t, backend, rejectingAddress, 0)
+}
+
+func TestPayWorkersSplitsBatchAboveMaxGasLimit(t *testing.T) {
+	p, store, backend := newTestPlugin(t)
+	p.maxGasLimit = 100_000
+	var due []internal.PoolPayout
+	for i := 1; i <= 4; i++ {
+		due = append(due, duePayout(workerAddress(i), 1e15))
+	}
+
+	p.payWorkers(due)
+	backend.Commit()
+	p.checkPayouts()
+
+	payouts := store.all()
+	if len(payouts) != 4 {
+		t.Fatalf("got %d payouts, want 4", len(payouts))
+	}
+	assertStatus(t, payouts, internal.PayoutConfirmed)
+	txs := make(map[string]bool)
+	for _, payout := range payouts {
+		txs[payout.TxHash] = true
+	}
+	if len(txs) < 2 {
+		t.Errorf("payouts were sent in %d transaction, want the batch split", len(txs))
+	}
+	for txHash := range txs {
+		receipt, err := backend.Client().TransactionReceipt(context.Background(), common.HexToHash(txHash))
+		if err != nil {
+			t.Fatalf("failed to get receipt of %s: %v", txHash, err)
+		}
+		if receipt.GasUsed > p.maxGasLimit {
+			t.Errorf("transaction %s used %d gas, more than %d", txHash, receipt.GasUsed, p.maxGasLimit)
+		}
+	}
+	for i := 1; i <= 4; i++ {
+		assertBalance(t, backend, workerAddress(i), 1e15)
+	}
+}
+
+// memStore keeps the payouts of a test in memory. The payout loop does not use the other
+// store methods when it sends and tracks payouts; calling one panics.
+type memStore struct {
+	internal.Store
+	mu      sync.Mutex
+	payouts []internal.PoolPayout
+}
+
+// all returns every payout in the order they were recorded.
+func (s *memStore) all() []internal.PoolPayout {
+	payouts, _ := s.GetPayouts("")
+	return payouts
+}
+
+func (s *memStore) GetPayouts(status string) ([]internal.PoolPayout, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var payouts []internal.PoolPayout
+	for _, payout := range s.payouts {
+		if status == "" || payout.Status == status {
+			payouts = append(payouts, payout)
+		}
+	}
+	return payouts, nil
+}
+
+func (s *memStore) RecordPayouts(payouts []internal.PoolPayout) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]int64, 0, len(payouts))
+	for _, payout := range payouts {
+		ids = append(ids, s.add(payout))
+	}
+	return ids, nil
+}
+
+func (s *memStore) ConfirmPayout(id int64, blockNumber uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	payout, err := s.submitted(id)
+	if err != nil {
+		return err
+	}
+	payout.Status = internal.PayoutConfirmed
+	payout.BlockNumber = blockNumber
+	return nil
+}
+
+func (s *memStore) FailPayout(id int64, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	payout, err := s.submitted(id)
+	if err != nil {
+		return err
+	}
+	payout.Status = internal.PayoutFailed
+	payout.Error = reason
+	return nil
+}
+
+func (s *memStore) ReplacePayouts(txHash string, replacement internal.PoolPayout) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	replaced := 0
+	for i := range s.payouts {
+		payout := s.payouts[i]
+		if payout.TxHash != txHash || payout.Status != internal.PayoutSubmitted {
+			continue
+		}
+		s.payouts[i].Status = internal.PayoutReplaced
+		next := replacement
+		next.EthAddress = payout.EthAddress
+		next.NodeType = payout.NodeType
+		next.Region = payout.Region
+		next.Fees = payout.Fees
+		next.Contract = payout.Contract
+		next.ReplacesID = &payout.ID
+		s.add(next)
+		replaced++
+	}
+	if replaced == 0 {
+		return fmt.Errorf("no payouts of transaction %s are submitted", txHash)
+	}
+	return nil
+}
+
+func (s *memStore) ReviveReplacedPayout(replacedID int64, replacementID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	replacement, err := s.submitted(replacementID)
+	if err != nil {
+		return err
+	}
+	replacement.Status = internal.PayoutReplaced
+	s.payouts[replacedID-1].Status = internal.PayoutSubmitted
+	return nil
+}
+
+func (s *memStore) SetPayoutsCancel(txHash string, cancelTxHash string, gasFeeCap internal.Wei, gasTipCap internal.Wei) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.payouts {
+		if s.payouts[i].TxHash == txHash && s.payouts[i].Status == internal.PayoutSubmitted {
+			now := time.Now()
+			s.payouts[i].CancelTxHash = cancelTxHash
+			s.payouts[i].CancelledAt = &now
+			s.payouts[i].GasFeeCap = gasFeeCap
+			s.payouts[i].GasTipCap = gasTipCap
+			s.payouts[i].Error = ""
+		}
+	}
+	return nil
+}
+
+func (s *memStore) SetPayoutsError(txHash string, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.payouts {
+		if s.payouts[i].TxHash == txHash && s.payouts[i].Status == internal.PayoutSubmitted {
+			s.payouts[i].Error = reason
+		}
+	}
+	return nil
+}
+
+// add stores a submitted payout and returns its ID. The caller holds s.mu.
+func (s *memStore) add(payout internal.PoolPayout) int64 {
+	payout.ID = int64(len(s.payouts) + 1)
+	payout.Status = internal.PayoutSubmitted
+	payout.CreatedAt = time.Now()
+	s.payouts = append(s.payouts, payout)
+	return payout.ID
+}
+
+// submitted returns a submitted payout. The caller holds s.mu.
+func (s *memStore) submitted(id int64) (*internal.PoolPayout, error) {
+	if id < 1 || id > int64(len(s.payouts)) || s.payouts[id-1].Status != internal.PayoutSubmitted {
+		return nil, fmt.Errorf("payout %d is not submitted", id)
+	}
+	return &s.payouts[id-1], nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	log "github.com/sirupsen/logrus"
+	"math/big"
+)
+
+var (
+	errNotConnected       = errors.New("payout loop is not connected to the Ethereum node")
+	errPayoutNotFound     = errors.New("payout not found")
+	errPayoutNotSubmitted = errors.New("payout is not submitted")
+	errPayoutNonceUnknown = errors.New("payout nonce is unknown")
+)
+
+// payoutFees returns the fees the transaction of a payout was sent with.
+func payoutFees(payout internal.PoolPayout) txFees {
+	return txFees{feeCap: payout.GasFeeCap.Big(), tipCap: payout.GasTipCap.Big()}
+}
+
+// replacePayout sends the stuck transaction of the payouts again with the same nonce and
+// bumped fees.
+func (p *PayoutLoopPlugin) replacePayout(ctx context.Context, payouts []internal.PoolPayout, payoutLogger *log.Entry) error {
+	payout := payouts[0]
+	fees, err := p.replacementFees(ctx, payoutFees(payout))
+	if err != nil {
+		return err
+	}
+	to, value, data, err := payoutCall(payouts)
+	if err != nil {
+		return err
+	}
+	tx, err := p.signTx(ctx, *payout.Nonce, to, value, data, fees)
+	if err != nil {
+		return err
+	}
+
+	// The replacement is stored before it is sent. If it never reaches the node, the replaced
+	// transaction is still tracked through it: it is revived once mined, or replaced again.
+	// The payouts of a batch are replaced together, so a batch is never split.
+	if err := p.store.ReplacePayouts(payout.TxHash, internal.PoolPayout{
+		TxHash:    tx.Hash().Hex(),
+		Nonce:     payout.Nonce,
+		GasFeeCap: internal.WeiFromBig(fees.feeCap),
+		GasTipCap: internal.WeiFromBig(fees.tipCap),
+	}); err != nil {
+		return fmt.Errorf("failed to record replacement: %w", err)
+	}
+	payoutLogger.WithFields(log.Fields{
+		"replacementTxHash": tx.Hash().Hex(),
+		"gasFeeCap":         fees.feeCap.String(),
+		"gasTipCap":         fees.tipCap.String(),
+	}).Warn("Payout transaction stuck, replacing it with higher fees")
+
+	if err := p.client.SendTransaction(ctx, tx); err != nil {
+		return fmt.Errorf("failed to send replacement: %w", err)
+	}
+	return nil
+}
+
+// resolveUsedNonce handles a submitted payout whose nonce was used by another transaction.
+// If that transaction is one of the payouts it replaced, that payout is revived, otherwise
+// the payout failed.
+func (p *PayoutLoopPlugin) resolveUsedNonce(ctx context.Context, payout internal.PoolPayout, payoutLogger *log.Entry) error {
+	replaced, err := p.store.GetPayouts(internal.PayoutReplaced)
+	if err != nil {
+		return fmt.Errorf("failed to fetch replaced payouts: %w", err)
+	}
+	for _, candidate := range replaced {
+		if candidate.Nonce == nil || *candidate.Nonce != *payout.Nonce || candidate.EthAddress != payout.EthAddress ||
+			candidate.NodeType != payout.NodeType || candidate.Region != payout.Region {
+			continue
+		}
+		_, err := p.client.TransactionReceipt(ctx, common.HexToHash(candidate.TxHash))
+		if errors.Is(err, ethereum.NotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get receipt of replaced payout %d: %w", candidate.ID, err)
+		}
+		payoutLogger.WithFields(log.Fields{
+			"replacedID":     candidate.ID,
+			"replacedTxHash": candidate.TxHash,
+		}).Warn("Replaced payout transaction was mined instead of its replacement")
+		return p.store.ReviveReplacedPayout(candidate.ID, payout.ID)
+	}
+
+	if payout.CancelTxHash != "" {
+		payoutLogger.WithField("cancelTxHash", payout.CancelTxHash).Warn("Payout transaction was cancelled, restoring pending fees")
+		return p.store.FailPayout(payout.ID, fmt.Sprintf("transaction cancelled by %s", payout.CancelTxHash))
+	}
+	payoutLogger.Warn("Payout transaction was dropped, restoring pending fees")
+	return p.store.FailPayout(payout.ID, "transaction dropped, its nonce was used by another transaction")
+}
+
+// cancelPayout sends a transfer of nothing to the pool's own address with the nonce of a
+// submitted payout and bumped fees. Once it is mined, the tracker fails the payout
+// and restores its pending fees.
+func (p *PayoutLoopPlugin) cancelPayout(id int64) (*types.Transaction, error) {
+	p.sendMu.Lock()
+	defer p.sendMu.Unlock()
+
+	if p.client == nil {
+		return nil, errNotConnected
+	}
+	payouts, err := p.store.GetPayouts("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch payouts: %w", err)
+	}
+	var payout *internal.PoolPayout
+	for i := range payouts {
+		if payouts[i].ID == id {
+			payout = &payouts[i]
+			break
+		}
+	}
+	switch {
+	case payout == nil:
+		return nil, errPayoutNotFound
+	case payout.Status != internal.PayoutSubmitted:
+		return nil, errPayoutNotSubmitted
+	case payout.Nonce == nil:
+		return nil, errPayoutNonceUnknown
+	}
+
+	return p.sendCancel(context.Background(), *payout, p.logger.WithFields(log.Fields{
+		"payoutID": id,
+		"txHash":   payout.TxHash,
+	}))
+}
+
+// sendCancel sends the transaction cancelling a submitted payout, with fees bumped from the
+// ones it was last sent with. A stuck cancellation is sent again the same way.
+func (p *PayoutLoopPlugin) sendCancel(ctx context.Context, payout internal.PoolPayout, payoutLogger *log.Entry) (*types.Transaction, error) {
+	fees, err := p.replacementFees(ctx, payoutFees(payout))
+	if err != nil {
+		return nil, err
+	}
+	from := crypto.PubkeyToAddress(p.privateKey.PublicKey)
+	tx, err := p.signTx(ctx, *payout.Nonce, from, new(big.Int), nil, fees)
+	if err != nil {
+		return nil, err
+	}
+	// The payouts of a batch share their transaction and are cancelled together.
+	if err := p.store.SetPayoutsCancel(payout.TxHash, tx.Hash().Hex(), internal.WeiFromBig(fees.feeCap), internal.WeiFromBig(fees.tipCap)); err != nil {
+		return nil, fmt.Errorf("failed to record cancellation: %w", err)
+	}
+	payoutLogger.WithFields(log.Fields{
+		"cancelTxHash":         tx.Hash().Hex(),
+		"previousCancelTxHash": payout.CancelTxHash,
+		"gasFeeCap":            fees.feeCap.String(),
+		"gasTipCap":            fees.tipCap.String(),
+	}).Warn("Cancelling payout")
+
+	if err := p.client.SendTransaction(ctx, tx); err != nil {
+		return nil, fmt.Errorf("failed to send cancellation: %w", err)
+	}
+	return tx, nil
+}
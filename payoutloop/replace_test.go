@@ -0,0 +1,158 @@
+package main
+
+import (
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplaceStuckBatch(t *testing.T) {
+	p, store, backend := newTestPlugin(t)
+	due := []internal.PoolPayout{
+		duePayout(workerAddress(1), 1e15),
+		duePayout(workerAddress(2), 2e15),
+	}
+	p.payWorkers(due)
+
+	// The batch is not mined before the stuck timeout and is replaced with bumped fees.
+	p.stuckTimeout = 0
+	p.checkPayouts()
+	p.stuckTimeout = time.Hour
+
+	payouts := store.all()
+	if len(payouts) != 4 {
+		t.Fatalf("got %d payouts, want 2 replaced and 2 replacements", len(payouts))
+	}
+	replaced, replacements := payouts[:2], payouts[2:]
+	assertStatus(t, replaced, internal.PayoutReplaced)
+	assertStatus(t, replacements, internal.PayoutSubmitted)
+	for i, replacement := range replacements {
+		original := replaced[i]
+		if replacement.ReplacesID == nil || *replacement.ReplacesID != original.ID {
+			t.Errorf("payout %d replaces %v, want %d", replacement.ID, replacement.ReplacesID, original.ID)
+		}
+		if replacement.TxHash == original.TxHash || replacement.TxHash != replacements[0].TxHash {
+			t.Errorf("payout %d has transaction %s, want one new transaction for the batch", replacement.ID, replacement.TxHash)
+		}
+		if *replacement.Nonce != *original.Nonce || replacement.Contract != original.Contract || replacement.Fees.Cmp(original.Fees) != 0 {
+			t.Errorf("replacement %+v does not resend %+v", replacement, original)
+		}
+		if replacement.GasFeeCap.Cmp(original.GasFeeCap) <= 0 || replacement.GasTipCap.Cmp(original.GasTipCap) <= 0 {
+			t.Errorf("replacement fees %s/%s are not above %s/%s", replacement.GasFeeCap, replacement.GasTipCap, original.GasFeeCap, original.GasTipCap)
+		}
+	}
+
+	backend.Commit()
+	p.checkPayouts()
+
+	payouts = store.all()
+	assertStatus(t, payouts[:2], internal.PayoutReplaced)
+	assertStatus(t, payouts[2:], internal.PayoutConfirmed)
+	assertBalance(t, backend, workerAddress(1), 1e15)
+	assertBalance(t, backend, workerAddress(2), 2e15)
+}
+
+func TestCancelBatch(t *testing.T) {
+	p, store, backend := newTestPlugin(t)
+	due := []internal.PoolPayout{
+		duePayout(workerAddress(1), 1e15),
+		duePayout(workerAddress(2), 2e15),
+	}
+	p.payWorkers(due)
+
+	cancelTx, err := p.cancelPayout(1)
+	if err != nil {
+		t.Fatalf("failed to cancel payout: %v", err)
+	}
+	for _, payout := range store.all() {
+		if payout.CancelTxHash != cancelTx.Hash().Hex() {
+			t.Errorf("payout %d has cancel transaction %q, want %s", payout.ID, payout.CancelTxHash, cancelTx.Hash().Hex())
+		}
+	}
+
+	backend.Commit()
+	p.checkPayouts()
+
+	payouts := store.all()
+	if len(payouts) != 2 {
+		t.Fatalf("got %d payouts, want 2", len(payouts))
+	}
+	assertStatus(t, payouts, internal.PayoutFailed)
+	for _, payout := range payouts {
+		if !strings.Contains(payout.Error, "cancelled") {
+			t.Errorf("payout %d failed with %q, want it cancelled", payout.ID, payout.Error)
+		}
+	}
+	assertBalance(t, backend, workerAddress(1), 0)
+	assertBalance(t, backend, workerAddress(2), 0)
+}
+
+func TestCancelPayoutNotSubmitted(t *testing.T) {
+	p, _, backend := newTestPlugin(t)
+	p.payWorkers([]internal.PoolPayout{duePayout(workerAddress(1), 1e15)})
+	backend.Commit()
+	p.checkPayouts()
+
+	if _, err := p.cancelPayout(1); err != errPayoutNotSubmitted {
+		t.Errorf("cancelling a confirmed payout returned %v, want %v", err, errPayoutNotSubmitted)
+	}
+	if _, err := p.cancelPayout(2); err != errPayoutNotFound {
+		t.Errorf("cancelling an unknown payout returned %v, want %v", err, errPayoutNotFound)
+	}
+}
+
+func TestStuckPayoutBeyondFeeLimit(t *testing.T) {
+	p, store, backend := newTestPlugin(t)
+	p.payWorkers([]internal.PoolPayout{duePayout(workerAddress(1), 1e15)})
+
+	// Bumping the fees would exceed the max fee, so the payout is not replaced and the
+	// reason is recorded on it.
+	p.maxFeePerGas = store.all()[0].GasFeeCap.Big()
+	p.stuckTimeout = 0
+	p.checkPayouts()
+	p.checkPayouts()
+
+	payouts := store.all()
+	if len(payouts) != 1 {
+		t.Fatalf("got %d payouts, want the stuck one only", len(payouts))
+	}
+	assertStatus(t, payouts, internal.PayoutSubmitted)
+	if !strings.Contains(payouts[0].Error, "not replaced") {
+		t.Errorf("stuck payout has error %q, want why it is not replaced", payouts[0].Error)
+	}
+
+	backend.Commit()
+	p.checkPayouts()
+	assertStatus(t, store.all(), internal.PayoutConfirmed)
+	assertBalance(t, backend, workerAddress(1), 1e15)
+}
+
+func TestReplaceStuckCancel(t *testing.T) {
+	p, store, backend := newTestPlugin(t)
+	p.payWorkers([]internal.PoolPayout{duePayout(workerAddress(1), 1e15)})
+	cancelTx, err := p.cancelPayout(1)
+	if err != nil {
+		t.Fatalf("failed to cancel payout: %v", err)
+	}
+	cancelled := store.all()[0]
+
+	// The cancellation is not mined before the stuck timeout and is sent again with bumped
+	// fees.
+	p.stuckTimeout = 0
+	p.checkPayouts()
+	p.stuckTimeout = time.Hour
+
+	payout := store.all()[0]
+	if payout.CancelTxHash == cancelTx.Hash().Hex() {
+		t.Fatalf("stuck cancellation %s was not replaced", cancelTx.Hash().Hex())
+	}
+	if payout.GasFeeCap.Cmp(cancelled.GasFeeCap) <= 0 || payout.GasTipCap.Cmp(cancelled.GasTipCap) <= 0 {
+		t.Errorf("replacement cancellation fees %s/%s are not above %s/%s", payout.GasFeeCap, payout.GasTipCap, cancelled.GasFeeCap, cancelled.GasTipCap)
+	}
+
+	backend.Commit()
+	p.checkPayouts()
+	assertStatus(t, store.all(), internal.PayoutFailed)
+	assertBalance(t, backend, workerAddress(1), 0)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// trackPayouts checks the submitted payouts on every receipt poll interval.
+func (p *PayoutLoopPlugin) trackPayouts() {
+	ticker := time.NewTicker(p.receiptPoll)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		p.checkPayouts()
+	}
+}
+
+// checkPayouts confirms the submitted payouts whose transaction has ConfirmationDepth
+// confirmations, fails the ones whose transaction reverted or was dropped and replaces the
+// ones that are stuck.
+func (p *PayoutLoopPlugin) checkPayouts() {
+	p.sendMu.Lock()
+	defer p.sendMu.Unlock()
+
+	payouts, err := p.store.GetPayouts(internal.PayoutSubmitted)
+	if err != nil {
+		p.logger.WithError(err).Error("Error fetching submitted payouts from store")
+		return
+	}
+	if len(payouts) == 0 {
+		return
+	}
+
+	ctx := context.Background()
+	head, err := p.client.BlockNumber(ctx)
+	if err != nil {
+		p.logger.WithError(err).Warn("Failed to get the latest block number")
+		return
+	}
+	for _, batch := range groupByTx(payouts) {
+		if err := p.checkPayout(ctx, head, batch); err != nil {
+			p.logger.WithFields(log.Fields{
+				"payoutID": batch[0].ID,
+				"txHash":   batch[0].TxHash,
+			}).WithError(err).Warn("Failed to check payout")
+		}
+	}
+}
+
+// groupByTx groups payouts by transaction, keeping their order.
+func groupByTx(payouts []internal.PoolPayout) [][]internal.PoolPayout {
+	var groups [][]internal.PoolPayout
+	index := make(map[string]int)
+	for _, payout := range payouts {
+		i, ok := index[payout.TxHash]
+		if !ok {
+			i = len(groups)
+			index[payout.TxHash] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], payout)
+	}
+	return groups
+}
+
+// stuckSince returns when the last transaction of a payout was sent: its cancellation, if
+// it was cancelled.
+func stuckSince(payout internal.PoolPayout) time.Time {
+	if payout.CancelTxHash != "" && payout.CancelledAt != nil {
+		return *payout.CancelledAt
+	}
+	return payout.CreatedAt
+}
+
+// unstickPayout sends the stuck transaction of submitted payouts again with bumped fees: the
+// cancellation if they were cancelled, otherwise the payouts. Once the fees cannot be bumped
+// within the limits anymore, this is recorded on the payouts, which stay submitted until a
+// transaction with their nonce is mined.
+func (p *PayoutLoopPlugin) unstickPayout(ctx context.Context, payouts []internal.PoolPayout, payoutLogger *log.Entry) error {
+	payout := payouts[0]
+	var err error
+	if payout.CancelTxHash != "" {
+		_, err = p.sendCancel(ctx, payout, payoutLogger.WithField("cancelTxHash", payout.CancelTxHash))
+	} else {
+		err = p.replacePayout(ctx, payouts, payoutLogger)
+	}
+	if !errors.Is(err, errFeeLimitExceeded) {
+		return err
+	}
+	if payout.Error != "" {
+		payoutLogger.WithField("error", payout.Error).Debug("Payout transaction stuck, fees cannot be bumped")
+		return nil
+	}
+	payoutLogger.WithError(err).Error("Payout transaction stuck and its fees cannot be bumped, raise the fee limits or wait for it to be mined")
+	return p.store.SetPayoutsError(payout.TxHash, fmt.Sprintf("transaction stuck, not replaced: %v", err))
+}
+
+// checkPayout checks the receipt of the transaction of submitted payouts, a single payout
+// or the payouts of one batch.
+func (p *PayoutLoopPlugin) checkPayout(ctx context.Context, head uint64, payouts []internal.PoolPayout) error {
+	payout := payouts[0]
+	payoutLogger := payoutsLogger(p.logger, payouts).WithFields(log.Fields{
+		"payoutID": payout.ID,
+		"txHash":   payout.TxHash,
+	})
+
+	// The nonce is read before the receipt, so a transaction mined in between is not
+	// mistaken for a dropped one.
+	var minedNonce uint64
+	if payout.Nonce != nil {
+		var err error
+		from := crypto.PubkeyToAddress(p.privateKey.PublicKey)
+		if minedNonce, err = p.client.NonceAt(ctx, from, nil); err != nil {
+			return fmt.Errorf("failed to get nonce: %w", err)
+		}
+	}
+
+	receipt, err := p.client.TransactionReceipt(ctx, common.HexToHash(payout.TxHash))
+	if errors.Is(err, ethereum.NotFound) {
+		if payout.Nonce != nil && minedNonce > *payout.Nonce {
+			// Another transaction with the same nonce was mined, this one never will be.
+			for _, used := range payouts {
+				if err := p.resolveUsedNonce(ctx, used, payoutLogger.WithField("payoutID", used.ID)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if payout.Nonce != nil && time.Since(stuckSince(payout)) > p.stuckTimeout {
+			return p.unstickPayout(ctx, payouts, payoutLogger)
+		}
+		payoutLogger.Debug("Payout transaction not mined yet")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get receipt: %w", err)
+	}
+
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		payoutLogger.WithField("blockNumber", receipt.BlockNumber).Warn("Payout transaction reverted, restoring pending fees")
+		for _, reverted := range payouts {
+			if err := p.store.FailPayout(reverted.ID, fmt.Sprintf("transaction reverted in block %s", receipt.BlockNumber)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	block := receipt.BlockNumber.Uint64()
+	if head < block || head-block+1 < p.confirmationDepth {
+		payoutLogger.WithFields(log.Fields{
+			"blockNumber": block,
+			"head":        head,
+		}).Debug("Payout transaction waiting for confirmations")
+		return nil
+	}
+	payoutLogger.WithField("blockNumber", block).Info("Payout confirmed")
+	for _, confirmed := range payouts {
+		if err := p.store.ConfirmPayout(confirmed.ID, block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
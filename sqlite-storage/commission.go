@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm/clause"
+)
+
+// AddCommissionPeriod stores a commission period, replacing the one with the same
+// EffectiveFrom.
+func (s *SqliteStoragePlugin) AddCommissionPeriod(period internal.CommissionPeriod) error {
+	s.logger.WithFields(log.Fields{
+		"effectiveFrom": period.EffectiveFrom,
+		"rate":          period.Rate,
+		"rules":         len(period.Schedule.Rules),
+	}).Info("Recording commission period")
+
+	period.EffectiveFrom = period.EffectiveFrom.UTC()
+	err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "effective_from"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rate", "schedule"}),
+	}).Create(&period).Error
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to record commission period")
+	}
+	return err
+}
+
+// GetCommissionHistory returns the commission periods sorted by EffectiveFrom.
+func (s *SqliteStoragePlugin) GetCommissionHistory() ([]internal.CommissionPeriod, error) {
+	var history []internal.CommissionPeriod
+	if err := s.db.Order("effective_from").Find(&history).Error; err != nil {
+		s.logger.WithError(err).Error("Failed to fetch commission history")
+		return nil, err
+	}
+	return history, nil
+}
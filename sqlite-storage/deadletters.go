@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// AddDeadLetter records an event that could not be parsed or applied. Recording the same
+// source event again replaces its envelope and error and counts another attempt.
+func (s *SqliteStoragePlugin) AddDeadLetter(letter internal.DeadLetter) error {
+	s.logger.WithFields(log.Fields{
+		"source":  letter.Source,
+		"eventID": letter.EventID,
+		"error":   letter.Error,
+	}).Warn("Recording dead letter")
+
+	letter.Attempts = 1
+	err := s.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "source"}, {Name: "event_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"node_type":   letter.NodeType,
+			"envelope":    letter.Envelope,
+			"error":       letter.Error,
+			"attempts":    gorm.Expr("attempts + 1"),
+			"updated_at":  time.Now().UTC(),
+			"resolved_at": nil,
+		}),
+	}).Create(&letter).Error
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to record dead letter")
+	}
+	return err
+}
+
+// GetDeadLetters returns the dead letters, including the resolved ones if all is set.
+func (s *SqliteStoragePlugin) GetDeadLetters(all bool) ([]internal.DeadLetter, error) {
+	s.logger.WithField("all", all).Debug("Retrieving dead letters")
+
+	query := s.db.Order("id")
+	if !all {
+		query = query.Where("resolved_at IS NULL")
+	}
+	var letters []internal.DeadLetter
+	if err := query.Find(&letters).Error; err != nil {
+		s.logger.WithError(err).Error("Failed to fetch dead letters")
+		return nil, err
+	}
+	return letters, nil
+}
+
+// GetDeadLetter returns a dead letter, or nil if it does not exist.
+func (s *SqliteStoragePlugin) GetDeadLetter(id int64) (*internal.DeadLetter, error) {
+	var letter internal.DeadLetter
+	err := s.db.First(&letter, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to fetch dead letter")
+		return nil, err
+	}
+	return &letter, nil
+}
+
+// ResolveDeadLetter marks a dead letter as reprocessed.
+func (s *SqliteStoragePlugin) ResolveDeadLetter(id int64) error {
+	s.logger.WithField("id", id).Info("Resolving dead letter")
+
+	err := s.db.Model(&internal.DeadLetter{}).Where("id = ?", id).
+		Update("resolved_at", time.Now().UTC()).Error
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to resolve dead letter")
+	}
+	return err
+}
+
+// FailDeadLetter records another failed attempt to reprocess a dead letter.
+func (s *SqliteStoragePlugin) FailDeadLetter(id int64, reason string) error {
+	s.logger.WithFields(log.Fields{
+		"id":     id,
+		"reason": reason,
+	}).Warn("Recording failed dead letter reprocessing")
+
+	err := s.db.Model(&internal.DeadLetter{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"error":    reason,
+		"attempts": gorm.Expr("attempts + 1"),
+	}).Error
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to update dead letter")
+	}
+	return err
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// AddProcessedJob records a processed job.
+func (s *SqliteStoragePlugin) AddProcessedJob(job internal.ProcessedJob) error {
+	s.logger.WithFields(log.Fields{
+		"source":     job.Source,
+		"requestID":  job.RequestID,
+		"ethAddress": job.EthAddress,
+		"fees":       job.Fees.String(),
+	}).Debug("Recording processed job")
+
+	if err := s.db.Create(&job).Error; err != nil {
+		s.logger.WithError(err).Error("Failed to record processed job")
+		return err
+	}
+	return nil
+}
+
+// GetProcessedJobs returns the processed jobs matching filter, newest first.
+func (s *SqliteStoragePlugin) GetProcessedJobs(filter internal.JobFilter) ([]internal.ProcessedJob, error) {
+	s.logger.WithField("filter", filter).Debug("Retrieving processed jobs")
+
+	query := s.db.Order("processed_at DESC, id DESC")
+	if filter.EthAddress != "" {
+		query = query.Where("eth_address = ?", filter.EthAddress)
+	}
+	if filter.NodeType != "" {
+		query = query.Where("node_type = ?", filter.NodeType)
+	}
+	if filter.Pipeline != "" {
+		query = query.Where("pipeline = ?", filter.Pipeline)
+	}
+	if filter.ModelID != "" {
+		query = query.Where("model_id = ?", filter.ModelID)
+	}
+	if filter.From != nil {
+		query = query.Where("processed_at >= ?", filter.From.UTC())
+	}
+	if filter.To != nil {
+		query = query.Where("processed_at < ?", filter.To.UTC())
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	if filter.Offset > 0 {
+		query = query.Offset(filter.Offset)
+	}
+
+	var jobs []internal.ProcessedJob
+	if err := query.Find(&jobs).Error; err != nil {
+		s.logger.WithError(err).Error("Failed to fetch processed jobs")
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// GetWorkerVolume returns the fees of the jobs a worker processed from (inclusive) to
+// (exclusive).
+func (s *SqliteStoragePlugin) GetWorkerVolume(ethAddress string, from time.Time, to time.Time) (internal.Wei, error) {
+	var fees []internal.Wei
+	err := s.db.Model(&internal.ProcessedJob{}).
+		Where("eth_address = ? AND processed_at >= ? AND processed_at < ?", ethAddress, from.UTC(), to.UTC()).
+		Pluck("fees", &fees).Error
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to fetch worker volume")
+		return internal.Wei{}, err
+	}
+	var volume internal.Wei
+	for _, fee := range fees {
+		volume = volume.Add(fee)
+	}
+	return volume, nil
+}
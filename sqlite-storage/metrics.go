@@ -0,0 +1,38 @@
+package main
+
+import (
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// GetWorkerMetrics returns the performance metrics of every worker with jobs, failures or
+// sessions between from and to.
+func (s *SqliteStoragePlugin) GetWorkerMetrics(from time.Time, to time.Time) ([]internal.WorkerMetrics, error) {
+	s.logger.WithFields(log.Fields{
+		"from": from,
+		"to":   to,
+	}).Debug("Computing worker metrics")
+	// Times are stored in UTC and compared as text.
+	from, to = from.UTC(), to.UTC()
+
+	var jobs []internal.ProcessedJob
+	if err := s.db.Select("eth_address", "node_type", "response_time", "processed_at").
+		Where("eth_address <> '' AND processed_at >= ? AND processed_at < ?", from, to).
+		Find(&jobs).Error; err != nil {
+		s.logger.WithError(err).Error("Failed to fetch processed jobs for metrics")
+		return nil, err
+	}
+	var failures []internal.JobFailure
+	if err := s.db.Where("received_at >= ? AND received_at < ?", from, to).Find(&failures).Error; err != nil {
+		s.logger.WithError(err).Error("Failed to fetch job failures for metrics")
+		return nil, err
+	}
+	var sessions []internal.WorkerSession
+	if err := s.db.Where("connected_at < ? AND (disconnected_at IS NULL OR disconnected_at > ?)", to, from).
+		Find(&sessions).Error; err != nil {
+		s.logger.WithError(err).Error("Failed to fetch worker sessions for metrics")
+		return nil, err
+	}
+	return internal.ComputeWorkerMetrics(jobs, failures, sessions, from, to), nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// AddOperatorLedgerEntry records a change of the pool operator's commission.
+func (s *SqliteStoragePlugin) AddOperatorLedgerEntry(entry internal.OperatorLedgerEntry) error {
+	s.logger.WithFields(log.Fields{
+		"kind":     entry.Kind,
+		"region":   entry.Region,
+		"nodeType": entry.NodeType,
+		"amount":   entry.Amount.String(),
+	}).Debug("Recording operator ledger entry")
+
+	if err := s.db.Create(&entry).Error; err != nil {
+		s.logger.WithError(err).Error("Failed to record operator ledger entry")
+		return err
+	}
+	return nil
+}
+
+// AddOperatorWithdrawal records a withdrawal of amount from the pool operator's commission
+// in a region and node type. It fails if amount exceeds the balance.
+func (s *SqliteStoragePlugin) AddOperatorWithdrawal(region string, nodeType string, amount internal.Wei, txHash string, note string) error {
+	withdrawalLogger := s.logger.WithFields(log.Fields{
+		"region":   region,
+		"nodeType": nodeType,
+		"amount":   amount.String(),
+		"txHash":   txHash,
+	})
+	withdrawalLogger.Info("Recording operator withdrawal")
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var amounts []internal.Wei
+		if err := tx.Model(&internal.OperatorLedgerEntry{}).
+			Where("region = ? AND node_type = ?", region, nodeType).
+			Pluck("amount", &amounts).Error; err != nil {
+			return err
+		}
+		var balance internal.Wei
+		for _, entryAmount := range amounts {
+			balance = balance.Add(entryAmount)
+		}
+		if balance.Cmp(amount) < 0 {
+			return fmt.Errorf("withdrawal of %s exceeds the operator balance of %s in %s/%s", amount, balance, region, nodeType)
+		}
+		return tx.Create(&internal.OperatorLedgerEntry{
+			Kind:     internal.OperatorWithdrawal,
+			Region:   region,
+			NodeType: nodeType,
+			Amount:   internal.Wei{}.Sub(amount),
+			TxHash:   txHash,
+			Note:     note,
+		}).Error
+	})
+	if err != nil {
+		withdrawalLogger.WithError(err).Error("Failed to record operator withdrawal")
+	}
+	return err
+}
+
+// GetOperatorLedger returns the pool operator ledger entries of a kind, or of every kind
+// if kind is empty, newest first.
+func (s *SqliteStoragePlugin) GetOperatorLedger(kind string) ([]internal.OperatorLedgerEntry, error) {
+	query := s.db.Order("id DESC")
+	if kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+	var entries []internal.OperatorLedgerEntry
+	if err := query.Find(&entries).Error; err != nil {
+		s.logger.WithError(err).Error("Failed to fetch operator ledger")
+		return nil, err
+	}
+	return entries, nil
+}
+
+// GetOperatorBalances returns the pool operator's commission per region and node type.
+func (s *SqliteStoragePlugin) GetOperatorBalances() ([]internal.OperatorBalance, error) {
+	// Amounts are text, so they are summed up in Go.
+	var entries []internal.OperatorLedgerEntry
+	if err := s.db.Select("kind", "region", "node_type", "amount").Find(&entries).Error; err != nil {
+		s.logger.WithError(err).Error("Failed to fetch operator ledger")
+		return nil, err
+	}
+	return internal.SumOperatorLedger(entries), nil
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"time"
+)
+
+// RecordPayout moves the payout's Fees from the worker's pending to its paid fees and
+// stores the payout, returning its ID. Status defaults to PayoutSubmitted.
+func (s *SqliteStoragePlugin) RecordPayout(payout internal.PoolPayout) (int64, error) {
+	s.logger.WithFields(log.Fields{
+		"ethAddress": payout.EthAddress,
+		"region":     payout.Region,
+		"nodeType":   payout.NodeType,
+		"amount":     payout.Fees.String(),
+		"txHash":     payout.TxHash,
+	}).Info("Recording paid fees")
+
+	if payout.Status == "" {
+		payout.Status = internal.PayoutSubmitted
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		txStore := &SqliteStoragePlugin{db: tx, config: s.config, logger: s.logger}
+		if err := txStore.movePaidFees(payout.EthAddress, payout.Region, payout.NodeType, payout.Fees); err != nil {
+			return err
+		}
+		// Create the pool payout record.
+		if err := tx.Create(&payout).Error; err != nil {
+			s.logger.WithError(err).Error("Failed to create pool payout record")
+			return err
+		}
+		s.logger.WithFields(log.Fields{
+			"ethAddress": payout.EthAddress,
+			"txHash":     payout.TxHash,
+			"amount":     payout.Fees.String(),
+		}).Info("Pool payout record created successfully")
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return payout.ID, nil
+}
+
+// RecordPayouts records the payouts of one transaction like RecordPayout, all or none, and
+// returns their IDs.
+func (s *SqliteStoragePlugin) RecordPayouts(payouts []internal.PoolPayout) ([]int64, error) {
+	ids := make([]int64, 0, len(payouts))
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		txStore := &SqliteStoragePlugin{db: tx, config: s.config, logger: s.logger}
+		for _, payout := range payouts {
+			id, err := txStore.RecordPayout(payout)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// movePaidFees moves amount from a worker's pending to its paid fees, or back for a
+// negative amount.
+func (s *SqliteStoragePlugin) movePaidFees(ethAddress string, region string, nodeType string, amount internal.Wei) error {
+	var worker internal.RemoteWorker
+	err := s.db.Where("eth_address = ? AND region = ? AND node_type = ?", ethAddress, region, nodeType).Take(&worker).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		msg := "No matching remote worker found to update paid fees"
+		s.logger.Warn(msg)
+		return errors.New(msg)
+	}
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to fetch worker for paid fees")
+		return err
+	}
+
+	if err := s.db.Model(&internal.RemoteWorker{}).
+		Where("eth_address = ? AND region = ? AND node_type = ?", ethAddress, region, nodeType).
+		Updates(map[string]interface{}{
+			"paid_fees":    worker.PaidFees.Add(amount),
+			"pending_fees": worker.PendingFees.Sub(amount),
+		}).Error; err != nil {
+		s.logger.WithError(err).Error("Failed to update paid/pending fees")
+		return err
+	}
+	return nil
+}
+
+// ConfirmPayout marks a submitted payout as confirmed in a block.
+func (s *SqliteStoragePlugin) ConfirmPayout(id int64, blockNumber uint64) error {
+	s.logger.WithFields(log.Fields{
+		"id":          id,
+		"blockNumber": blockNumber,
+	}).Info("Confirming payout")
+
+	now := time.Now().UTC()
+	result := s.db.Model(&internal.PoolPayout{}).
+		Where("id = ? AND status = ?", id, internal.PayoutSubmitted).
+		Updates(map[string]interface{}{
+			"status":       internal.PayoutConfirmed,
+			"block_number": blockNumber,
+			"confirmed_at": now,
+		})
+	if result.Error != nil {
+		s.logger.WithError(result.Error).Error("Failed to confirm payout")
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("payout %d is not submitted", id)
+	}
+	return nil
+}
+
+// FailPayout marks a submitted payout as failed and moves its Fees from the worker's paid
+// fees back to its pending fees.
+func (s *SqliteStoragePlugin) FailPayout(id int64, reason string) error {
+	failLogger := s.logger.WithFields(log.Fields{
+		"id":     id,
+		"reason": reason,
+	})
+	failLogger.Warn("Failing payout")
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var payout internal.PoolPayout
+		if err := tx.First(&payout, id).Error; err != nil {
+			return err
+		}
+		if payout.Status != internal.PayoutSubmitted {
+			return fmt.Errorf("payout %d is %s, not submitted", id, payout.Status)
+		}
+		if err := tx.Model(&payout).Updates(map[string]interface{}{
+			"status": internal.PayoutFailed,
+			"error":  reason,
+		}).Error; err != nil {
+			return err
+		}
+		txStore := &SqliteStoragePlugin{db: tx, config: s.config, logger: s.logger}
+		return txStore.movePaidFees(payout.EthAddress, payout.Region, payout.NodeType, internal.Wei{}.Sub(payout.Fees))
+	})
+	if err != nil {
+		failLogger.WithError(err).Error("Failed to fail payout")
+	}
+	return err
+}
+
+// ReplacePayout marks a submitted payout as replaced and stores its replacement, which has
+// the same amount, as submitted. The worker balances do not change.
+func (s *SqliteStoragePlugin) ReplacePayout(id int64, replacement internal.PoolPayout) error {
+	replaceLogger := s.logger.WithFields(log.Fields{
+		"id":     id,
+		"txHash": replacement.TxHash,
+	})
+	replaceLogger.Info("Replacing payout")
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var payout internal.PoolPayout
+		if err := tx.First(&payout, id).Error; err != nil {
+			return err
+		}
+		if payout.Status != internal.PayoutSubmitted {
+			return fmt.Errorf("payout %d is %s, not submitted", id, payout.Status)
+		}
+		if err := tx.Model(&payout).Update("status", internal.PayoutReplaced).Error; err != nil {
+			return err
+		}
+
+		replacement.ID = 0
+		replacement.EthAddress = payout.EthAddress
+		replacement.NodeType = payout.NodeType
+		replacement.Region = payout.Region
+		replacement.Fees = payout.Fees
+		replacement.Status = internal.PayoutSubmitted
+		replacement.ReplacesID = &payout.ID
+		return tx.Create(&replacement).Error
+	})
+	if err != nil {
+		replaceLogger.WithError(err).Error("Failed to replace payout")
+	}
+	return err
+}
+
+// ReplacePayouts replaces the submitted payouts of a transaction like ReplacePayout, all or
+// none. Each replacement gets the Contract of the payout it replaces.
+func (s *SqliteStoragePlugin) ReplacePayouts(txHash string, replacement internal.PoolPayout) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var payouts []internal.PoolPayout
+		if err := tx.Where("tx_hash = ? AND status = ?", txHash, internal.PayoutSubmitted).Order("id").Find(&payouts).Error; err != nil {
+			return err
+		}
+		if len(payouts) == 0 {
+			return fmt.Errorf("no payouts of transaction %s are submitted", txHash)
+		}
+		txStore := &SqliteStoragePlugin{db: tx, config: s.config, logger: s.logger}
+		for _, payout := range payouts {
+			replacement.Contract = payout.Contract
+			if err := txStore.ReplacePayout(payout.ID, replacement); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.logger.WithField("txHash", txHash).WithError(err).Error("Failed to replace payouts")
+	}
+	return err
+}
+
+// ReviveReplacedPayout marks a replaced payout whose transaction was mined after all as
+// submitted again, and the submitted payout that replaced it as replaced.
+func (s *SqliteStoragePlugin) ReviveReplacedPayout(replacedID int64, replacementID int64) error {
+	reviveLogger := s.logger.WithFields(log.Fields{
+		"replacedID":    replacedID,
+		"replacementID": replacementID,
+	})
+	reviveLogger.Warn("Reviving replaced payout that was mined")
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&internal.PoolPayout{}).
+			Where("id = ? AND status = ?", replacedID, internal.PayoutReplaced).
+			Update("status", internal.PayoutSubmitted)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("payout %d is not replaced", replacedID)
+		}
+		result = tx.Model(&internal.PoolPayout{}).
+			Where("id = ? AND status = ?", replacementID, internal.PayoutSubmitted).
+			Update("status", internal.PayoutReplaced)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("payout %d is not submitted", replacementID)
+		}
+		return nil
+	})
+	if err != nil {
+		reviveLogger.WithError(err).Error("Failed to revive replaced payout")
+	}
+	return err
+}
+
+// SetPayoutsCancel records the transaction sent to cancel the submitted payouts of a
+// transaction.
+func (s *SqliteStoragePlugin) SetPayoutsCancel(txHash string, cancelTxHash string, gasFeeCap internal.Wei, gasTipCap internal.Wei) error {
+	s.logger.WithFields(log.Fields{
+		"txHash":       txHash,
+		"cancelTxHash": cancelTxHash,
+	}).Info("Recording payout cancellation")
+
+	result := s.db.Model(&internal.PoolPayout{}).
+		Where("tx_hash = ? AND status = ?", txHash, internal.PayoutSubmitted).
+		Updates(map[string]interface{}{
+			"cancel_tx_hash": cancelTxHash,
+			"cancelled_at":   time.Now().UTC(),
+			"gas_fee_cap":    gasFeeCap,
+			"gas_tip_cap":    gasTipCap,
+			"error":          "",
+		})
+	if result.Error != nil {
+		s.logger.WithError(result.Error).Error("Failed to record payout cancellation")
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("no payouts of transaction %s are submitted", txHash)
+	}
+	return nil
+}
+
+// SetPayoutsError records why the stuck transaction of the submitted payouts of a
+// transaction is not replaced anymore. The payouts stay submitted.
+func (s *SqliteStoragePlugin) SetPayoutsError(txHash string, reason string) error {
+	s.logger.WithFields(log.Fields{
+		"txHash": txHash,
+		"reason": reason,
+	}).Warn("Recording stuck payout error")
+
+	result := s.db.Model(&internal.PoolPayout{}).
+		Where("tx_hash = ? AND status = ?", txHash, internal.PayoutSubmitted).
+		Update("error", reason)
+	if result.Error != nil {
+		s.logger.WithError(result.Error).Error("Failed to record stuck payout error")
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("no payouts of transaction %s are submitted", txHash)
+	}
+	return nil
+}
+
+// migrateLegacyPayouts marks the payouts recorded before payouts had a status as confirmed,
+// since their amounts were already counted as paid.
+func (s *SqliteStoragePlugin) migrateLegacyPayouts() error {
+	return s.db.Model(&internal.PoolPayout{}).
+		Where("status IS NULL OR status = ''").
+		Update("status", internal.PayoutConfirmed).Error
+}
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"fmt"
+	"errors"
 	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
 	pool "github.com/Livepeer-Open-Pool/openpool-plugin"
 	"github.com/Livepeer-Open-Pool/openpool-plugin/config"
@@ -9,18 +9,21 @@ import (
 	log "github.com/sirupsen/logrus"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"time"
 )
 
 // SqliteStoragePlugin provides shared storage.
 type SqliteStoragePlugin struct {
-	db     *gorm.DB
-	config *config.Config
-	logger *log.Entry
+	db           *gorm.DB
+	config       *config.Config
+	workerFilter *internal.WorkerFilterConfig
+	logger       *log.Entry
 }
 
 // Ensure StoragePlugin implements pool.StorageInterface ✅
 var _ pool.StorageInterface = &SqliteStoragePlugin{}
+var _ internal.Store = &SqliteStoragePlugin{}
 
 // NewSqliteStoragePlugin returns a new NewSqliteStoragePlugin instance.
 func NewSqliteStoragePlugin() pool.StorageInterface {
@@ -39,18 +42,55 @@ func (s *SqliteStoragePlugin) Init(config *config.Config) {
 	if err != nil {
 		s.logger.WithError(err).Fatal("Failed to connect to sqlite storage")
 	}
+	// Fee amounts are updated read-modify-write, so writers are serialized on one connection.
+	sqlDb, err := gormDb.DB()
+	if err != nil {
+		s.logger.WithError(err).Fatal("Failed to access sqlite connection pool")
+	}
+	sqlDb.SetMaxOpenConns(1)
 
 	// AutoMigrate or any other DB initialization here.
-	err = gormDb.AutoMigrate(&internal.RemoteWorker{}, &internal.EventLog{}, &internal.PoolPayout{})
-	if err := gormDb.AutoMigrate(&internal.RemoteWorker{}, &internal.EventLog{}, &internal.PoolPayout{}); err != nil {
+	if err := migrate(gormDb); err != nil {
 		s.logger.WithError(err).Fatal("Failed to migrate database schema")
 	}
 	s.db = gormDb
 	s.config = config
 
+	extCfg, err := internal.LoadConfig()
+	if err != nil {
+		s.logger.WithError(err).Fatal("Failed to load storage config")
+	}
+	s.workerFilter = extCfg.WorkerFilter
+
+	if err := s.migrateUnattributedWorkers(); err != nil {
+		s.logger.WithError(err).Fatal("Failed to move unattributed worker fees to the unattributed ledger")
+	}
+	if err := s.migrateLegacyPayouts(); err != nil {
+		s.logger.WithError(err).Fatal("Failed to set the status of payouts recorded without one")
+	}
+
 	s.logger.Info("SqliteStoragePlugin initialized successfully")
 }
 
+// migrate creates or updates the schema of all tables.
+func migrate(db *gorm.DB) error {
+	return db.AutoMigrate(
+		&internal.RemoteWorker{},
+		&internal.EventLog{},
+		&internal.PoolPayout{},
+		&internal.DataSourceCursor{},
+		&internal.JobCorrelation{},
+		&internal.UnattributedFee{},
+		&internal.WorkerSession{},
+		&internal.DataSourceHealth{},
+		&internal.DeadLetter{},
+		&internal.ProcessedJob{},
+		&internal.JobFailure{},
+		&internal.CommissionPeriod{},
+		&internal.OperatorLedgerEntry{},
+	)
+}
+
 // AddEvent stores an event.
 func (s *SqliteStoragePlugin) AddEvent(event models.PoolEvent) error {
 	s.logger.WithFields(log.Fields{
@@ -69,6 +109,62 @@ func (s *SqliteStoragePlugin) AddEvent(event models.PoolEvent) error {
 	}
 	return err
 }
+
+// ApplyEvent stores an event ingested from a data source and calls apply with a store bound
+// to the same transaction. It returns false without calling apply if the event was already
+// stored for that source.
+func (s *SqliteStoragePlugin) ApplyEvent(event *internal.EventLog, apply func(tx internal.Store) error) (bool, error) {
+	applied := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		txStore := &SqliteStoragePlugin{db: tx, config: s.config, logger: s.logger}
+		stored, err := txStore.addSourceEvent(event)
+		if err != nil || !stored {
+			return err
+		}
+		if err := apply(txStore); err != nil {
+			return err
+		}
+		applied = true
+		return nil
+	})
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to apply event, rolled back")
+		return false, err
+	}
+	return applied, nil
+}
+
+// Transaction calls fn with a store bound to one transaction, which is committed if fn
+// succeeds. The database has a single connection, so every other read and write waits
+// until it ends.
+func (s *SqliteStoragePlugin) Transaction(fn func(tx internal.Store) error) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&SqliteStoragePlugin{db: tx, config: s.config, logger: s.logger})
+	})
+}
+
+// addSourceEvent stores an event ingested from a data source. It returns false without
+// storing anything if the event was already stored for that source.
+func (s *SqliteStoragePlugin) addSourceEvent(event *internal.EventLog) (bool, error) {
+	eventLogger := s.logger.WithFields(log.Fields{
+		"source":    event.Source,
+		"eventID":   event.EventID,
+		"eventType": event.Type,
+	})
+	eventLogger.Debug("Adding source event to storage")
+
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(event)
+	if result.Error != nil {
+		eventLogger.WithError(result.Error).Error("Failed to add source event")
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		eventLogger.Debug("Source event already stored")
+		return false, nil
+	}
+	return true, nil
+}
+
 func (s *SqliteStoragePlugin) GetLastEventTimestamp() (time.Time, error) {
 	s.logger.Debug("Retrieving last event timestamp")
 	var maxUnixTime int64
@@ -88,19 +184,171 @@ func (s *SqliteStoragePlugin) GetLastEventTimestamp() (time.Time, error) {
 	s.logger.WithField("lastEventTime", lastTime).Debug("Fetched last event timestamp")
 	return lastTime, nil
 }
+
+// GetCursor returns the ingestion cursor of a data source, or nil if none was stored yet.
+func (s *SqliteStoragePlugin) GetCursor(source string) (*internal.DataSourceCursor, error) {
+	s.logger.WithField("source", source).Debug("Retrieving data source cursor")
+
+	var cursors []internal.DataSourceCursor
+	if err := s.db.Where("source = ?", source).Limit(1).Find(&cursors).Error; err != nil {
+		s.logger.WithError(err).Error("Failed to fetch data source cursor")
+		return nil, err
+	}
+	if len(cursors) == 0 {
+		return nil, nil
+	}
+	return &cursors[0], nil
+}
+
+// SetCursor stores the ingestion cursor of a data source.
+func (s *SqliteStoragePlugin) SetCursor(cursor internal.DataSourceCursor) error {
+	s.logger.WithFields(log.Fields{
+		"source":      cursor.Source,
+		"lastEventID": cursor.LastEventID,
+	}).Debug("Storing data source cursor")
+
+	if err := s.db.Save(&cursor).Error; err != nil {
+		s.logger.WithError(err).Error("Failed to store data source cursor")
+		return err
+	}
+	return nil
+}
+
+// GetDataSourceHealth returns the stored health of all data sources.
+func (s *SqliteStoragePlugin) GetDataSourceHealth() ([]internal.DataSourceHealth, error) {
+	s.logger.Debug("Retrieving data source health")
+
+	var health []internal.DataSourceHealth
+	if err := s.db.Order("source").Find(&health).Error; err != nil {
+		s.logger.WithError(err).Error("Failed to fetch data source health")
+		return nil, err
+	}
+	return health, nil
+}
+
+// SetDataSourceHealth stores the health of a data source.
+func (s *SqliteStoragePlugin) SetDataSourceHealth(health internal.DataSourceHealth) error {
+	s.logger.WithFields(log.Fields{
+		"source": health.Source,
+		"state":  health.State,
+	}).Debug("Storing data source health")
+
+	if err := s.db.Save(&health).Error; err != nil {
+		s.logger.WithError(err).Error("Failed to store data source health")
+		return err
+	}
+	return nil
+}
+
+// AddJobCorrelation stores the worker a job was received by.
+func (s *SqliteStoragePlugin) AddJobCorrelation(correlation internal.JobCorrelation) error {
+	s.logger.WithFields(log.Fields{
+		"source":     correlation.Source,
+		"requestID":  correlation.RequestID,
+		"ethAddress": correlation.EthAddress,
+	}).Debug("Storing job correlation")
+
+	if err := s.db.Save(&correlation).Error; err != nil {
+		s.logger.WithError(err).Error("Failed to store job correlation")
+		return err
+	}
+	return nil
+}
+
+// TakeJobCorrelation removes and returns the correlation of a job, or nil if there is none.
+func (s *SqliteStoragePlugin) TakeJobCorrelation(source string, requestID string) (*internal.JobCorrelation, error) {
+	s.logger.WithFields(log.Fields{
+		"source":    source,
+		"requestID": requestID,
+	}).Debug("Taking job correlation")
+
+	var correlations []internal.JobCorrelation
+	if err := s.db.Where("source = ? AND request_id = ?", source, requestID).Limit(1).Find(&correlations).Error; err != nil {
+		s.logger.WithError(err).Error("Failed to fetch job correlation")
+		return nil, err
+	}
+	if len(correlations) == 0 {
+		return nil, nil
+	}
+	if err := s.db.Delete(&correlations[0]).Error; err != nil {
+		s.logger.WithError(err).Error("Failed to delete job correlation")
+		return nil, err
+	}
+	return &correlations[0], nil
+}
+
+// ExpireJobCorrelations removes and returns the correlations of a data source that expired
+// at or before now.
+func (s *SqliteStoragePlugin) ExpireJobCorrelations(source string, now time.Time) ([]internal.JobCorrelation, error) {
+	s.logger.WithFields(log.Fields{
+		"source": source,
+		"now":    now,
+	}).Debug("Expiring job correlations")
+
+	var expired []internal.JobCorrelation
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("source = ? AND expires_at <= ?", source, now).Find(&expired).Error; err != nil {
+			return err
+		}
+		if len(expired) == 0 {
+			return nil
+		}
+		failures := make([]internal.JobFailure, len(expired))
+		for i, correlation := range expired {
+			failures[i] = internal.JobFailure{
+				Source:     correlation.Source,
+				RequestID:  correlation.RequestID,
+				EthAddress: correlation.EthAddress,
+				NodeType:   correlation.NodeType,
+				Pipeline:   correlation.Pipeline,
+				ModelID:    correlation.ModelID,
+				ReceivedAt: correlation.ReceivedAt,
+				ExpiredAt:  now,
+			}
+		}
+		if err := tx.Create(&failures).Error; err != nil {
+			return err
+		}
+		return tx.Where("source = ? AND expires_at <= ?", source, now).Delete(&internal.JobCorrelation{}).Error
+	})
+	if err != nil {
+		s.logger.WithError(err).Error("Failed to expire job correlations")
+		return nil, err
+	}
+	return expired, nil
+}
+
+// GetFilteredWorkers returns the connected workers that pass the configured worker filter
+// policy.
 func (s *SqliteStoragePlugin) GetFilteredWorkers() ([]models.Worker, error) {
-	s.logger.Debug("Retrieving filtered workers (is_connected = true)")
+	s.logger.Debug("Retrieving filtered workers")
 
-	//TODO: Basic check only makes sure they are online, need to add performance based checks here.... NEED iNPUT CRITIERA TOO
 	var remoteWorkers []*internal.RemoteWorker
-	if err := s.db.Find(&remoteWorkers).Where("is_connected = ?", true).Error; err != nil {
+	if err := s.db.Where("is_connected = ?", true).Find(&remoteWorkers).Error; err != nil {
 		s.logger.WithError(err).Error("Failed to fetch filtered workers")
 		return nil, err
 	}
 
-	workers := make([]models.Worker, len(remoteWorkers))
-	for i, rw := range remoteWorkers {
-		workers[i] = rw
+	var allowed map[string]bool
+	if s.workerFilter != nil {
+		to := time.Now().UTC()
+		metrics, err := s.GetWorkerMetrics(to.Add(-s.workerFilter.Window()), to)
+		if err != nil {
+			return nil, err
+		}
+		allowed = make(map[string]bool)
+		for _, m := range metrics {
+			allowed[m.EthAddress+"/"+m.NodeType] = s.workerFilter.Allows(m)
+		}
+	}
+
+	workers := make([]models.Worker, 0, len(remoteWorkers))
+	for _, rw := range remoteWorkers {
+		if pass, ok := allowed[rw.EthAddress+"/"+rw.NodeType]; ok && !pass {
+			s.logger.WithField("ethAddress", rw.EthAddress).Debug("Worker filtered out by policy")
+			continue
+		}
+		workers = append(workers, rw)
 	}
 	return workers, nil
 }
@@ -171,104 +419,92 @@ func (s *SqliteStoragePlugin) ResetWorkersOnlineStatus(region string, nodeType s
 	return result.Error
 }
 
+// AddPendingFees credits wei to a worker's pending fees. Amounts beyond int64 go through
+// AddPendingFeesWei.
 func (s *SqliteStoragePlugin) AddPendingFees(ethAddress string, amount int64, region string, nodeType string) error {
+	return s.AddPendingFeesWei(ethAddress, internal.NewWei(amount), region, nodeType)
+}
+
+// AddPendingFeesWei credits wei to a worker's pending fees, creating the worker if needed.
+// Amounts are stored as text, so they are added up in Go within a transaction.
+func (s *SqliteStoragePlugin) AddPendingFeesWei(ethAddress string, amount internal.Wei, region string, nodeType string) error {
 	s.logger.WithFields(log.Fields{
 		"ethAddress": ethAddress,
 		"region":     region,
 		"nodeType":   nodeType,
-		"amount":     amount,
+		"amount":     amount.String(),
 	}).Debug("Adding pending fees to worker")
 
-	result := s.db.Model(&internal.RemoteWorker{}).
-		Where("eth_address = ? AND region = ? AND node_type = ?", ethAddress, region, nodeType).
-		Update("pending_fees", gorm.Expr("pending_fees + ?", amount))
-	if result.Error != nil {
-		s.logger.WithError(result.Error).Error("Failed to add pending fees")
-		return result.Error
-	}
-	if result.RowsAffected == 0 {
-		s.logger.Debug("No matching worker found; creating a new record with initial pending fees")
-
-		worker := internal.RemoteWorker{
-			EthAddress:  ethAddress,
-			Region:      region,
-			NodeType:    nodeType,
-			IsConnected: false,
-			PendingFees: amount,
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var worker internal.RemoteWorker
+		err := tx.Where("eth_address = ? AND region = ? AND node_type = ?", ethAddress, region, nodeType).Take(&worker).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.logger.Debug("No matching worker found; creating a new record with initial pending fees")
+
+			worker = internal.RemoteWorker{
+				EthAddress:  ethAddress,
+				Region:      region,
+				NodeType:    nodeType,
+				IsConnected: false,
+				PendingFees: amount,
+			}
+			if err := tx.Create(&worker).Error; err != nil {
+				s.logger.WithError(err).Error("Failed to create new worker record for pending fees")
+				return err
+			}
+			return nil
 		}
-		if err := s.db.Create(&worker).Error; err != nil {
-			s.logger.WithError(err).Error("Failed to create new worker record for pending fees")
+		if err != nil {
+			s.logger.WithError(err).Error("Failed to fetch worker for pending fees")
 			return err
 		}
-	}
-	return nil
-}
-
-// RecordPayout stores a payout.
-func (s *SqliteStoragePlugin) AddPaidFees(ethAddress string, amount int64, txHash string, region string, nodeType string) error {
-	s.logger.WithFields(log.Fields{
-		"ethAddress": ethAddress,
-		"region":     region,
-		"nodeType":   nodeType,
-		"amount":     amount,
-		"txHash":     txHash,
-	}).Info("Recording paid fees")
 
-	return s.db.Transaction(func(tx *gorm.DB) error {
-		result := s.db.Model(&internal.RemoteWorker{}).
+		if err := tx.Model(&internal.RemoteWorker{}).
 			Where("eth_address = ? AND region = ? AND node_type = ?", ethAddress, region, nodeType).
-			Update("paid_fees", gorm.Expr("paid_fees + ?", amount)).
-			Update("pending_fees", gorm.Expr("pending_fees - ?", amount))
-		if result.Error != nil {
-			s.logger.WithError(result.Error).Error("Failed to update paid/pending fees")
-			return result.Error
-		}
-		if result.RowsAffected == 0 {
-			msg := fmt.Sprintf("No matching remote worker found to update paid fees")
-			s.logger.Warn(msg)
-			return fmt.Errorf(msg)
-		}
-		// Create the pool payout record.
-		payout := &internal.PoolPayout{
-			EthAddress: ethAddress,
-			TxHash:     txHash,
-			Fees:       amount,
-		}
-		if err := s.db.Create(payout).Error; err != nil {
-			s.logger.WithError(err).Error("Failed to create pool payout record")
+			Update("pending_fees", worker.PendingFees.Add(amount)).Error; err != nil {
+			s.logger.WithError(err).Error("Failed to add pending fees")
 			return err
-		} else {
-			s.logger.WithFields(log.Fields{
-				"ethAddress": ethAddress,
-				"txHash":     txHash,
-				"amount":     amount,
-			}).Info("Pool payout record created successfully")
 		}
-
 		return nil
 	})
 }
 
+// AddPaidFees moves wei from a worker's pending to its paid fees and records the payout.
+// Amounts beyond int64 go through AddPaidFeesWei.
+func (s *SqliteStoragePlugin) AddPaidFees(ethAddress string, amount int64, txHash string, region string, nodeType string) error {
+	return s.AddPaidFeesWei(ethAddress, internal.NewWei(amount), txHash, region, nodeType)
+}
+
+// AddPaidFeesWei moves wei from a worker's pending to its paid fees and records the payout.
+func (s *SqliteStoragePlugin) AddPaidFeesWei(ethAddress string, amount internal.Wei, txHash string, region string, nodeType string) error {
+	_, err := s.RecordPayout(internal.PoolPayout{
+		EthAddress: ethAddress,
+		NodeType:   nodeType,
+		Region:     region,
+		TxHash:     txHash,
+		Fees:       amount,
+	})
+	return err
+}
+
 func (s *SqliteStoragePlugin) GetPendingFees() (float64, error) {
 	s.logger.Debug("Retrieving total pending fees")
 
-	var totalPendingFees int64
-	err := s.db.Model(&internal.RemoteWorker{}).Select("SUM(pending_fees)").Scan(&totalPendingFees).Error
+	totalPendingFees, err := s.sumWorkerFees("pending_fees")
 	if err != nil {
 		s.logger.WithError(err).Error("Failed to fetch total pending fees")
 		return 0, err
 	}
 
 	// Convert wei to ether
-	ether := float64(totalPendingFees) / 1e18
+	ether := totalPendingFees.Eth()
 	s.logger.WithField("totalPendingETH", ether).Debug("Computed total pending fees in ETH")
 	return ether, nil
 }
 
 func (s *SqliteStoragePlugin) GetPaidFees() (float64, error) {
 	s.logger.Debug("Retrieving total paid fees")
-	var totalPaidFees int64
-	err := s.db.Model(&internal.RemoteWorker{}).Select("SUM(paid_fees)").Scan(&totalPaidFees).Error
+	totalPaidFees, err := s.sumWorkerFees("paid_fees")
 	if err != nil {
 		s.logger.WithError(err).Error("Failed to fetch total paid fees")
 
@@ -276,11 +512,25 @@ func (s *SqliteStoragePlugin) GetPaidFees() (float64, error) {
 	}
 
 	// Convert wei to ether
-	ether := float64(totalPaidFees) / 1e18
+	ether := totalPaidFees.Eth()
 	s.logger.WithField("totalPaidETH", ether).Debug("Computed total paid fees in ETH")
 
 	return ether, nil
 }
 
+// sumWorkerFees adds up a fee column of all workers. SQL SUM would convert the text
+// amounts to floating point.
+func (s *SqliteStoragePlugin) sumWorkerFees(column string) (internal.Wei, error) {
+	var amounts []internal.Wei
+	if err := s.db.Model(&internal.RemoteWorker{}).Pluck(column, &amounts).Error; err != nil {
+		return internal.Wei{}, err
+	}
+	var total internal.Wei
+	for _, amount := range amounts {
+		total = total.Add(amount)
+	}
+	return total, nil
+}
+
 // Exported symbol for plugin loading
 var PluginInstance SqliteStoragePlugin
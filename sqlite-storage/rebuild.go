@@ -0,0 +1,105 @@
+package main
+
+import (
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// NewScratchStore returns an empty in-memory sqlite store, e.g. to replay events into.
+func (s *SqliteStoragePlugin) NewScratchStore() (internal.Store, error) {
+	s.logger.Debug("Creating scratch store")
+
+	gormDb, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+	// Every connection opens its own in-memory database, so only one may be used.
+	sqlDb, err := gormDb.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDb.SetMaxOpenConns(1)
+	if err := migrate(gormDb); err != nil {
+		return nil, err
+	}
+	return &SqliteStoragePlugin{
+		db:     gormDb,
+		config: s.config,
+		logger: s.logger.WithField("storageFile", ":memory:"),
+	}, nil
+}
+
+// GetEventLog returns up to limit stored events with an ID above afterID, in ID order.
+func (s *SqliteStoragePlugin) GetEventLog(afterID int64, limit int) ([]internal.EventLog, error) {
+	s.logger.WithFields(log.Fields{
+		"afterID": afterID,
+		"limit":   limit,
+	}).Debug("Retrieving event log")
+
+	var events []internal.EventLog
+	if err := s.db.Where("id > ?", afterID).Order("id").Limit(limit).Find(&events).Error; err != nil {
+		s.logger.WithError(err).Error("Failed to fetch event log")
+		return nil, err
+	}
+	return events, nil
+}
+
+// GetRemoteWorkers returns all workers with their balances and connection state.
+func (s *SqliteStoragePlugin) GetRemoteWorkers() ([]internal.RemoteWorker, error) {
+	s.logger.Debug("Retrieving remote workers")
+
+	var workers []internal.RemoteWorker
+	if err := s.db.Find(&workers).Error; err != nil {
+		s.logger.WithError(err).Error("Failed to fetch remote workers")
+		return nil, err
+	}
+	return workers, nil
+}
+
+// SetWorkerBalances overwrites the balances and connection state of the given workers,
+// creating the ones that do not exist.
+func (s *SqliteStoragePlugin) SetWorkerBalances(workers []internal.RemoteWorker) error {
+	s.logger.WithField("numWorkers", len(workers)).Info("Overwriting worker balances")
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for _, worker := range workers {
+			result := tx.Model(&internal.RemoteWorker{}).
+				Where("eth_address = ? AND region = ? AND node_type = ?", worker.EthAddress, worker.Region, worker.NodeType).
+				Updates(map[string]interface{}{
+					"pending_fees": worker.PendingFees,
+					"paid_fees":    worker.PaidFees,
+					"is_connected": worker.IsConnected,
+				})
+			if result.Error != nil {
+				s.logger.WithError(result.Error).Error("Failed to overwrite worker balances")
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				if err := tx.Create(&worker).Error; err != nil {
+					s.logger.WithError(err).Error("Failed to create worker record")
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// GetPayouts returns the payouts with a status, or all payouts if status is empty, in the
+// order they were recorded.
+func (s *SqliteStoragePlugin) GetPayouts(status string) ([]internal.PoolPayout, error) {
+	s.logger.WithField("status", status).Debug("Retrieving payouts")
+
+	query := s.db.Order("id")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var payouts []internal.PoolPayout
+	if err := query.Find(&payouts).Error; err != nil {
+		s.logger.WithError(err).Error("Failed to fetch payouts")
+		return nil, err
+	}
+	return payouts, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"time"
+)
+
+// OpenWorkerSession marks a worker as connected to the orchestrator of a data source,
+// ending the session it may still have there.
+func (s *SqliteStoragePlugin) OpenWorkerSession(session internal.WorkerSession) error {
+	s.logger.WithFields(log.Fields{
+		"source":     session.Source,
+		"ethAddress": session.EthAddress,
+		"region":     session.Region,
+		"nodeType":   session.NodeType,
+	}).Debug("Opening worker session")
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&internal.WorkerSession{}).
+			Where("source = ? AND eth_address = ? AND disconnected_at IS NULL", session.Source, session.EthAddress).
+			Update("disconnected_at", session.ConnectedAt).Error; err != nil {
+			s.logger.WithError(err).Error("Failed to end previous worker session")
+			return err
+		}
+		if err := tx.Create(&session).Error; err != nil {
+			s.logger.WithError(err).Error("Failed to create worker session")
+			return err
+		}
+		txStore := &SqliteStoragePlugin{db: tx, config: s.config, logger: s.logger}
+		return txStore.UpdateWorkerStatus(session.EthAddress, true, session.Region, session.NodeType)
+	})
+}
+
+// CloseWorkerSession ends a worker's session on a data source. The worker stays connected
+// while it has sessions on other data sources.
+func (s *SqliteStoragePlugin) CloseWorkerSession(source string, ethAddress string, region string, nodeType string, at time.Time) error {
+	s.logger.WithFields(log.Fields{
+		"source":     source,
+		"ethAddress": ethAddress,
+		"region":     region,
+		"nodeType":   nodeType,
+	}).Debug("Closing worker session")
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&internal.WorkerSession{}).
+			Where("source = ? AND eth_address = ? AND disconnected_at IS NULL", source, ethAddress).
+			Update("disconnected_at", at).Error; err != nil {
+			s.logger.WithError(err).Error("Failed to close worker session")
+			return err
+		}
+		txStore := &SqliteStoragePlugin{db: tx, config: s.config, logger: s.logger}
+		return txStore.refreshWorkerConnected(ethAddress, region, nodeType)
+	})
+}
+
+// CloseSourceSessions ends all sessions on a data source, e.g. when its orchestrator resets.
+func (s *SqliteStoragePlugin) CloseSourceSessions(source string, region string, nodeType string, at time.Time) error {
+	s.logger.WithFields(log.Fields{
+		"source":   source,
+		"region":   region,
+		"nodeType": nodeType,
+	}).Info("Closing all worker sessions of data source")
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&internal.WorkerSession{}).
+			Where("source = ? AND disconnected_at IS NULL", source).
+			Update("disconnected_at", at).Error; err != nil {
+			s.logger.WithError(err).Error("Failed to close worker sessions")
+			return err
+		}
+
+		// Workers connected before sessions were recorded have none, so every connected
+		// worker of the node type is checked.
+		var workers []internal.RemoteWorker
+		if err := tx.Where("region = ? AND node_type = ? AND is_connected = ?", region, nodeType, true).Find(&workers).Error; err != nil {
+			s.logger.WithError(err).Error("Failed to fetch connected workers")
+			return err
+		}
+		txStore := &SqliteStoragePlugin{db: tx, config: s.config, logger: s.logger}
+		for _, worker := range workers {
+			if err := txStore.refreshWorkerConnected(worker.EthAddress, region, nodeType); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// refreshWorkerConnected sets a worker connected if it has an open session on any data source.
+func (s *SqliteStoragePlugin) refreshWorkerConnected(ethAddress string, region string, nodeType string) error {
+	var open int64
+	if err := s.db.Model(&internal.WorkerSession{}).
+		Where("eth_address = ? AND region = ? AND node_type = ? AND disconnected_at IS NULL", ethAddress, region, nodeType).
+		Count(&open).Error; err != nil {
+		s.logger.WithError(err).Error("Failed to count open worker sessions")
+		return err
+	}
+	return s.UpdateWorkerStatus(ethAddress, open > 0, region, nodeType)
+}
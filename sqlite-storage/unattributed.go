@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"github.com/Livepeer-Open-Pool/openpool-manager/internal"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"time"
+)
+
+// AddUnattributedFees records fees that could not be attributed to a worker.
+func (s *SqliteStoragePlugin) AddUnattributedFees(fee internal.UnattributedFee) error {
+	s.logger.WithFields(log.Fields{
+		"source":    fee.Source,
+		"eventID":   fee.EventID,
+		"requestID": fee.RequestID,
+		"amount":    fee.Fees.String(),
+	}).Info("Recording unattributed fees")
+
+	if err := s.db.Create(&fee).Error; err != nil {
+		s.logger.WithError(err).Error("Failed to record unattributed fees")
+		return err
+	}
+	return nil
+}
+
+// GetUnattributedFees returns the unattributed fees, including the reassigned ones if all is set.
+func (s *SqliteStoragePlugin) GetUnattributedFees(all bool) ([]internal.UnattributedFee, error) {
+	s.logger.WithField("all", all).Debug("Retrieving unattributed fees")
+
+	query := s.db.Order("id")
+	if !all {
+		query = query.Where("reassigned_to = ''")
+	}
+	var fees []internal.UnattributedFee
+	if err := query.Find(&fees).Error; err != nil {
+		s.logger.WithError(err).Error("Failed to fetch unattributed fees")
+		return nil, err
+	}
+	return fees, nil
+}
+
+// ReassignUnattributedFees credits unattributed fees to a worker's pending fees, or to the
+// pool operator if ethAddress is internal.PoolOperator.
+func (s *SqliteStoragePlugin) ReassignUnattributedFees(id int64, ethAddress string) error {
+	reassignLogger := s.logger.WithFields(log.Fields{
+		"id":         id,
+		"ethAddress": ethAddress,
+	})
+	reassignLogger.Info("Reassigning unattributed fees")
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var fee internal.UnattributedFee
+		if err := tx.First(&fee, id).Error; err != nil {
+			return err
+		}
+		if fee.ReassignedTo != "" {
+			return fmt.Errorf("unattributed fees %d were already reassigned to %s", id, fee.ReassignedTo)
+		}
+
+		now := time.Now().UTC()
+		if err := tx.Model(&fee).Updates(map[string]interface{}{
+			"reassigned_to": ethAddress,
+			"reassigned_at": now,
+		}).Error; err != nil {
+			return err
+		}
+		txStore := &SqliteStoragePlugin{db: tx, config: s.config, logger: s.logger}
+		if ethAddress == internal.PoolOperator {
+			return txStore.AddOperatorLedgerEntry(internal.OperatorLedgerEntry{
+				Kind:      internal.OperatorReassigned,
+				Region:    fee.Region,
+				NodeType:  fee.NodeType,
+				Amount:    fee.Fees,
+				Source:    fee.Source,
+				EventID:   fee.EventID,
+				RequestID: fee.RequestID,
+			})
+		}
+		return txStore.AddPendingFeesWei(ethAddress, fee.Fees, fee.Region, fee.NodeType)
+	})
+	if err != nil {
+		reassignLogger.WithError(err).Error("Failed to reassign unattributed fees")
+	}
+	return err
+}
+
+// migrateUnattributedWorkers moves the fees that were credited to workers without an
+// address into the unattributed ledger.
+func (s *SqliteStoragePlugin) migrateUnattributedWorkers() error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var workers []internal.RemoteWorker
+		if err := tx.Where("eth_address = '' AND pending_fees NOT IN ('0', '')").Find(&workers).Error; err != nil {
+			return err
+		}
+		for _, worker := range workers {
+			s.logger.WithFields(log.Fields{
+				"region":   worker.Region,
+				"nodeType": worker.NodeType,
+				"amount":   worker.PendingFees.String(),
+			}).Warn("Moving fees of worker without an address to the unattributed ledger")
+
+			if err := tx.Create(&internal.UnattributedFee{
+				Source:   "migration",
+				NodeType: worker.NodeType,
+				Region:   worker.Region,
+				Fees:     worker.PendingFees,
+			}).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&internal.RemoteWorker{}).
+				Where("eth_address = '' AND region = ? AND node_type = ?", worker.Region, worker.NodeType).
+				Update("pending_fees", internal.Wei{}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}